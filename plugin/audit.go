@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"gopkg.in/yaml.v3"
+)
+
+// auditFunc reviews the value at path for security exposure. Like
+// treeValidatorFunc, each one decides by itself whether path is relevant.
+type auditFunc func(path string, tree config.TreeReader) []config.ValidationResult
+
+// auditChecks make up the security audit. Their findings are Warnings for
+// exposure the configuration can avoid and Info for exposure that comes
+// with the stack's design; none of them blocks a deployment.
+var auditChecks = []auditFunc{
+	auditAdminPorts,
+	auditImageTag,
+	auditPlainHTTP,
+	auditPlexHostNetwork,
+	auditLetsEncryptEmail,
+	auditDNSSEC,
+}
+
+// validateSecurityAudit reports the security findings for path, see
+// auditChecks. The secrets in the Compose file are audited by
+// validateCompose, which renders it.
+func validateSecurityAudit(path string, tree config.TreeReader) ([]config.ValidationResult, error) {
+	var results []config.ValidationResult
+	for _, fn := range auditChecks {
+		results = append(results, fn(path, tree)...)
+	}
+	return results, nil
+}
+
+// finding returns an audit result. Its message starts with "Security:" so
+// the findings stand out from the other validation results.
+func finding(severity config.Severity, format string, args ...any) config.ValidationResult {
+	return config.ValidationResult{
+		Message:  "Security: " + fmt.Sprintf(format, args...),
+		Severity: severity,
+	}
+}
+
+// adminPorts are the host ports of administration interfaces, keyed by
+// port path, with the name used in findings and the path of their bind
+// address.
+var adminPorts = map[string]struct{ Name, BindPath string }{
+	"pihole/web-port":                {"The PiHole admin interface", "pihole/web-bind-address"},
+	"nginx-proxy-manager/admin-port": {"The Nginx Proxy Manager admin interface", "nginx-proxy-manager/admin-bind-address"},
+}
+
+// auditAdminPorts reports admin interfaces published on all interfaces of
+// the host, where every network the host is connected to can reach them.
+func auditAdminPorts(path string, tree config.TreeReader) []config.ValidationResult {
+	port, ok := adminPorts[path]
+	if !ok {
+		return nil
+	}
+	name, bindPath := port.Name, port.BindPath
+	addr := bindAddress(tree, bindPath)
+	if a, err := netip.ParseAddr(addr); addr != "" && (err != nil || !a.IsUnspecified()) {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Warning,
+		"%s listens on all interfaces of the host. Set %s to the LAN address of the server, or to 127.0.0.1 and reach it through an SSH tunnel.",
+		name, bindPath)}
+}
+
+// auditImageTag reports images that follow the latest tag.
+func auditImageTag(path string, tree config.TreeReader) []config.ValidationResult {
+	if !strings.HasSuffix(path, "/image-tag") || treeString(tree, path) != "latest" {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Warning,
+		"The latest tag deploys whatever the registry serves at the time, including untested major upgrades and compromised releases. Pin a version tag and update it deliberately.")}
+}
+
+// auditPlainHTTP reports service URLs that use plain HTTP although a
+// domain is set, so logins cross the network unencrypted.
+func auditPlainHTTP(path string, tree config.TreeReader) []config.ValidationResult {
+	component, ok := strings.CutSuffix(path, "/url")
+	if _, web := webServices[component]; !ok || !web || treeString(tree, "core/domain") == "" {
+		return nil
+	}
+	url := treeString(tree, path)
+	if !strings.HasPrefix(url, "http://") {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Warning,
+		"%s is served over plain HTTP although core/domain is set, so passwords cross the network unencrypted. Enable nginx-proxy-manager to publish it over HTTPS.",
+		url)}
+}
+
+// auditPlexHostNetwork reports that Plex runs with host networking, which
+// its discovery protocols need.
+func auditPlexHostNetwork(path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "plex/web-port" {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Info,
+		"Plex uses network_mode: host for DLNA and GDM discovery, so its ports are open on every interface of the host and bind addresses do not apply. Restrict them in the host firewall to the LAN.")}
+}
+
+// auditLetsEncryptEmail reports a Let's Encrypt email that was only
+// derived from core/domain, which names a mailbox that may not exist. An
+// empty email is already rejected as required.
+func auditLetsEncryptEmail(path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "nginx-proxy-manager/letsencrypt-email" {
+		return nil
+	}
+	domain := treeString(tree, "core/domain")
+	if domain == "" || treeString(tree, path) != "admin@"+domain {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Warning,
+		"No Let's Encrypt email is set; admin@%s is derived from core/domain and may not exist. Set an address you read to recover the ACME account and hear about certificate problems.",
+		domain)}
+}
+
+// auditDNSSEC reports PiHole running without DNSSEC validation.
+func auditDNSSEC(path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "pihole/dnssec" {
+		return nil
+	}
+	if v, ok := tree.Get(path); !ok || v.Val != false {
+		return nil
+	}
+	return []config.ValidationResult{finding(config.Warning,
+		"DNSSEC validation is off, so forged answers for signed domains reach the clients unnoticed. Turn pihole/dnssec on unless an upstream server breaks with it.")}
+}
+
+// secretEnvName matches the names of environment variables holding
+// secrets. Variables ending in _FILE name a file with the secret instead.
+var secretEnvName = regexp.MustCompile(`(?i)(PASSWORD|PASSWD|SECRET|TOKEN|CLAIM|API_?KEY|PRIVATE_?KEY)`)
+
+// auditComposeSecrets reports the secrets written into the environment of
+// the rendered Compose file f in plain text. Anyone who can read the file,
+// or run docker inspect, can read them. It is a Warning while the file is
+// missing or readable by others, since zhi writes it with the default
+// permissions, and Info once only its owner can read it.
+func auditComposeSecrets(f renderedFile) []config.ValidationResult {
+	var doc struct {
+		Services map[string]struct {
+			Environment any `yaml:"environment"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(f.Content), &doc); err != nil {
+		return nil
+	}
+	var found []string
+	for name, svc := range doc.Services {
+		for key, val := range environmentVars(svc.Environment) {
+			if val == "" || strings.HasSuffix(strings.ToUpper(key), "_FILE") || !secretEnvName.MatchString(key) {
+				continue
+			}
+			if ref := strings.TrimSpace(val); strings.HasPrefix(ref, "${") && strings.HasSuffix(ref, "}") {
+				continue
+			}
+			found = append(found, name+" "+key)
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	slices.Sort(found)
+	severity := config.Warning
+	if info, err := os.Stat(f.Output); err == nil && info.Mode().Perm()&0o077 == 0 {
+		severity = config.Info
+	}
+	file := filepath.Base(f.Output)
+	return []config.ValidationResult{finding(severity,
+		"%s contains secrets in plain text (%s), readable by anyone who can read the file or run docker inspect. Keep it private with chmod 600 %s and limit who is in the docker group.",
+		file, strings.Join(found, ", "), file)}
+}
+
+// environmentVars returns the variables of a Compose environment, given
+// as a map or as a list of NAME=value entries.
+func environmentVars(env any) map[string]string {
+	vars := map[string]string{}
+	switch env := env.(type) {
+	case map[string]any:
+		for k, v := range env {
+			if v != nil {
+				vars[k] = fmt.Sprint(v)
+			}
+		}
+	case []any:
+		for _, e := range env {
+			s, _ := e.(string)
+			if k, v, ok := strings.Cut(s, "="); ok {
+				vars[k] = v
+			}
+		}
+	}
+	return vars
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// noFinding marks test cases that expect no audit finding, since
+// config.Info is the zero Severity.
+const noFinding config.Severity = -1
+
+func TestValidateSecurityAudit(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   map[string]any
+		path     string
+		severity config.Severity
+		contains string
+	}{
+		{"admin port on all interfaces", nil, "nginx-proxy-manager/admin-port", config.Warning, "nginx-proxy-manager/admin-bind-address"},
+		{"admin port on unspecified address", map[string]any{"pihole/web-bind-address": "0.0.0.0"}, "pihole/web-port", config.Warning, "pihole/web-bind-address"},
+		{"admin port on LAN address", map[string]any{"pihole/web-bind-address": "192.168.1.10"}, "pihole/web-port", noFinding, ""},
+		{"admin port on core bind address", map[string]any{"core/bind-address": "192.168.1.10"}, "pihole/web-port", noFinding, ""},
+		{"per-port address overrides core", map[string]any{"core/bind-address": "192.168.1.10", "pihole/web-bind-address": "0.0.0.0"}, "pihole/web-port", config.Warning, "pihole/web-bind-address"},
+		{"admin port on localhost", map[string]any{"nginx-proxy-manager/admin-bind-address": "127.0.0.1"}, "nginx-proxy-manager/admin-port", noFinding, ""},
+		{"other port on all interfaces", nil, "nextcloud/web-port", noFinding, ""},
+		{"latest tag", map[string]any{"plex/image-tag": "latest"}, "plex/image-tag", config.Warning, "Pin a version tag"},
+		{"pinned tag", map[string]any{"plex/image-tag": "1.41.3"}, "plex/image-tag", noFinding, ""},
+		{"http url with domain", map[string]any{"core/domain": "example.com", "nextcloud/url": "http://example.com:8080/"}, "nextcloud/url", config.Warning, "http://example.com:8080/"},
+		{"https url with domain", map[string]any{"core/domain": "example.com", "nextcloud/url": "https://cloud.example.com/"}, "nextcloud/url", noFinding, ""},
+		{"http url without domain", map[string]any{"nextcloud/url": "http://localhost:8080/"}, "nextcloud/url", noFinding, ""},
+		{"plex host network", nil, "plex/web-port", config.Info, "network_mode: host"},
+		{"derived letsencrypt email", map[string]any{"core/domain": "example.com", "nginx-proxy-manager/letsencrypt-email": "admin@example.com"}, "nginx-proxy-manager/letsencrypt-email", config.Warning, "admin@example.com"},
+		{"own letsencrypt email", map[string]any{"core/domain": "example.com", "nginx-proxy-manager/letsencrypt-email": "me@mail.example.net"}, "nginx-proxy-manager/letsencrypt-email", noFinding, ""},
+		{"dnssec off", map[string]any{"pihole/dnssec": false}, "pihole/dnssec", config.Warning, "DNSSEC"},
+		{"dnssec on", map[string]any{"pihole/dnssec": true}, "pihole/dnssec", noFinding, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := config.NewTree()
+			for path, val := range tt.values {
+				tree.Set(path, &config.Value{Val: val})
+			}
+			results, err := validateSecurityAudit(tt.path, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.severity == noFinding {
+				if len(results) > 0 {
+					t.Errorf("unexpected findings %v", results)
+				}
+				return
+			}
+			if len(results) != 1 {
+				t.Fatalf("results %v, want one finding", results)
+			}
+			r := results[0]
+			if r.Severity != tt.severity || !strings.HasPrefix(r.Message, "Security: ") || !strings.Contains(r.Message, tt.contains) {
+				t.Errorf("finding %v, want %v containing %q", r, tt.severity, tt.contains)
+			}
+		})
+	}
+}
+
+func TestAdminPortsMatchHostPorts(t *testing.T) {
+	for path, port := range adminPorts {
+		i := slices.IndexFunc(hostPortDefs, func(d hostPortDef) bool { return d.Path == path })
+		if i < 0 {
+			t.Errorf("%s is not a host port", path)
+			continue
+		}
+		if bp := hostPortDefs[i].BindPath; bp != port.BindPath {
+			t.Errorf("%s: bind path %s, want %s as in hostPortDefs", path, port.BindPath, bp)
+		}
+	}
+}
+
+func TestAuditComposeSecrets(t *testing.T) {
+	const compose = `services:
+  db:
+    image: mariadb:11
+    environment:
+      MARIADB_ROOT_PASSWORD: "s3cret"
+      MARIADB_PASSWORD_FILE: /run/secrets/db
+      MARIADB_USER: nextcloud
+  app:
+    image: nextcloud:30
+    environment:
+      - ADMIN_TOKEN=abc
+      - SMTP_PASSWORD=${SMTP_PASSWORD}
+      - EMPTY_SECRET=
+  web:
+    image: nginx:1
+`
+	tests := []struct {
+		name     string
+		content  string
+		mode     os.FileMode // 0: no file on disk
+		severity config.Severity
+	}{
+		{"file not written yet", compose, 0, config.Warning},
+		{"readable by others", compose, 0o644, config.Warning},
+		{"private file", compose, 0o600, config.Info},
+		{"no secrets", "services:\n  web:\n    image: nginx:1\n    environment:\n      TZ: UTC\n", 0, noFinding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output := filepath.Join(t.TempDir(), "docker-compose.yml")
+			if tt.mode != 0 {
+				if err := os.WriteFile(output, []byte(tt.content), tt.mode); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(output, tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			f := renderedFile{exportTemplate: exportTemplate{Name: composeTemplate, Output: output}, Content: tt.content}
+			results := auditComposeSecrets(f)
+			if tt.severity == noFinding {
+				if len(results) > 0 {
+					t.Errorf("unexpected findings %v", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].Severity != tt.severity {
+				t.Fatalf("results %v, want one %v finding", results, tt.severity)
+			}
+			if want := "(app ADMIN_TOKEN, db MARIADB_ROOT_PASSWORD)"; !strings.Contains(results[0].Message, want) {
+				t.Errorf("message %q, want it to contain %q", results[0].Message, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"gopkg.in/yaml.v3"
+)
+
+// cliUsage describes the commands the plugin binary accepts when run
+// directly instead of being launched by zhi.
+const cliUsage = `Usage: zhi-config-homeserver [command] [flags]
+
+Without a command the binary serves the zhi config and transform plugins.
+Commands read the component state of the zhi workspace in the working
+directory (or ZHI_WORKSPACE). Outside a workspace all components count as
+enabled.
+
+Commands:
+  list                         print all config paths
+  get [-json] <path>           print the value of a path
+  defaults [-format yaml|json|env]
+                               print all default values
+  validate -values <file>      validate a values file and exit 1 on blocking results
+  doctor [-values <file>]      validate with checks of this host (ports, devices, paths)
+  render [-values <file>] [-components <list>] [-workspace <dir>] [-out <dir>] [template...]
+                               render the workspace's export templates offline
+  schema                       print the JSON Schema (draft 2020-12) of the configuration values
+`
+
+// cliCommand runs a command with its arguments and returns the exit code.
+type cliCommand func(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int
+
+// cliCommands maps command names to their implementations.
+var cliCommands = map[string]cliCommand{
+	"list":     cmdList,
+	"get":      cmdGet,
+	"defaults": cmdDefaults,
+	"validate": cmdValidate,
+	"doctor":   cmdDoctor,
+	"render":   cmdRender,
+	"schema":   cmdSchema,
+}
+
+// runCLI runs the command in args and returns the process exit code.
+func runCLI(args []string, stdout, stderr io.Writer) int {
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, cliUsage)
+		return 0
+	}
+	cmd, ok := cliCommands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], cliUsage)
+		return 2
+	}
+	p := newHomeserverPlugin()
+	if p.loadErr != nil {
+		fmt.Fprintf(stderr, "cannot load values overlay: %v\n", p.loadErr)
+		return 1
+	}
+	return cmd(p, args[1:], stdout, stderr)
+}
+
+// newFlagSet returns a flag set for command that reports errors to stderr.
+func newFlagSet(command string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	return fs
+}
+
+func cmdList(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		fmt.Fprintln(stderr, "list takes no arguments")
+		return 2
+	}
+	paths, _ := p.List(context.Background())
+	for _, path := range paths {
+		fmt.Fprintln(stdout, path)
+	}
+	return 0
+}
+
+func cmdGet(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("get", stderr)
+	asJSON := fs.Bool("json", false, "print the value with its metadata as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: get [-json] <path>")
+		return 2
+	}
+	path := fs.Arg(0)
+	v, ok, _ := p.Get(context.Background(), path)
+	if !ok {
+		fmt.Fprintf(stderr, "unknown config path %q\n", path)
+		return 1
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(map[string]any{"value": v.Val, "metadata": v.Metadata}); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Fprintln(stdout, v.Val)
+	return 0
+}
+
+func cmdDefaults(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("defaults", stderr)
+	format := fs.String("format", "yaml", "output format: yaml, json or env")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// Computed values are left out: they are not defaults and a values
+	// file containing them would be rejected.
+	defaults := map[string]any{}
+	for _, path := range p.paths {
+		if p.defs[path].Compute != nil {
+			continue
+		}
+		v, _, _ := p.Get(context.Background(), path)
+		defaults[path] = v.Val
+	}
+	switch *format {
+	case "yaml":
+		enc := yaml.NewEncoder(stdout)
+		enc.SetIndent(2)
+		if err := enc.Encode(defaults); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	case "json":
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(defaults); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	case "env":
+		for _, path := range slices.Sorted(maps.Keys(defaults)) {
+			s, _ := toString(defaults[path])
+			fmt.Fprintf(stdout, "%s=%s\n", envName(path), escapeEnvFile(s))
+		}
+	default:
+		fmt.Fprintf(stderr, "unknown format %q (use yaml, json or env)\n", *format)
+		return 2
+	}
+	return 0
+}
+
+func cmdValidate(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("validate", stderr)
+	file := fs.String("values", "", "values file (YAML or JSON) to validate")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *file == "" || fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: validate -values <file>")
+		return 2
+	}
+	return validateValues(p, *file, stdout, stderr)
+}
+
+func cmdDoctor(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("doctor", stderr)
+	file := fs.String("values", "", "values file (YAML or JSON) applied on top of the defaults")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintln(stderr, "usage: doctor [-values <file>]")
+		return 2
+	}
+	p.host = localHost
+	return validateValues(p, *file, stdout, stderr)
+}
+
+// validateValues validates the plugin's values with the values file
+// applied, if any, prints the results and returns the exit code.
+func validateValues(p *homeserverPlugin, file string, stdout, stderr io.Writer) int {
+	results := map[string][]config.ValidationResult{}
+	if file != "" && !applyValuesFile(p, file, results, stderr) {
+		return 1
+	}
+	found, err := p.validateTree(p.currentTree())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	for path, r := range found {
+		results[path] = append(results[path], r...)
+	}
+	if printResults(stdout, results) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// applyValuesFile sets the values of file on p and adds a Blocking result
+// for every rejected value to results. It reports false if the file
+// cannot be read.
+func applyValuesFile(p *homeserverPlugin, file string, results map[string][]config.ValidationResult, stderr io.Writer) bool {
+	values, err := readValuesFile(file)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return false
+	}
+	for path, err := range p.applyValues(values) {
+		results[path] = append(results[path], config.ValidationResult{Message: err.Error(), Severity: config.Blocking})
+	}
+	return true
+}
+
+// printResults prints validation results sorted by path and returns the
+// number of blocking results, which it reports last.
+func printResults(w io.Writer, results map[string][]config.ValidationResult) int {
+	blocking := 0
+	for _, path := range slices.Sorted(maps.Keys(results)) {
+		for _, r := range results[path] {
+			fmt.Fprintf(w, "%-8s %s: %s\n", r.Severity, path, r.Message)
+			if r.Severity == config.Blocking {
+				blocking++
+			}
+		}
+	}
+	if blocking > 0 {
+		fmt.Fprintf(w, "%d blocking problem(s)\n", blocking)
+	}
+	return blocking
+}
+
+func cmdRender(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("render", stderr)
+	file := fs.String("values", "", "values file (YAML or JSON) applied on top of the defaults")
+	components := fs.String("components", "", "comma-separated components to enable (default: the workspace's component state)")
+	dir := fs.String("workspace", p.workspaceDir, "workspace directory containing zhi.yaml and the templates")
+	out := fs.String("out", "", "directory to write the rendered files to (default: stdout)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	// The values overlay and validation belong to the chosen workspace
+	// too, not only its templates and component state.
+	if *dir != p.workspaceDir {
+		host := p.host
+		if p = newWorkspacePlugin(*dir); p.loadErr != nil {
+			fmt.Fprintf(stderr, "cannot load values overlay: %v\n", p.loadErr)
+			return 1
+		}
+		p.host = host
+	}
+
+	st, err := loadComponentState(*dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if *components != "" {
+		if st, err = st.withOnly(splitList(*components, ",")); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 2
+		}
+	}
+	p.components = st
+
+	tmpls, err := loadExportTemplates(*dir)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	if names := fs.Args(); len(names) > 0 {
+		for _, name := range names {
+			if !slices.ContainsFunc(tmpls, func(t exportTemplate) bool { return t.Name == name }) {
+				fmt.Fprintf(stderr, "unknown template %q\n", name)
+				return 2
+			}
+		}
+		tmpls = slices.DeleteFunc(tmpls, func(t exportTemplate) bool { return !slices.Contains(names, t.Name) })
+	}
+
+	if *file != "" {
+		rejected := map[string][]config.ValidationResult{}
+		if !applyValuesFile(p, *file, rejected, stderr) {
+			return 1
+		}
+		if printResults(stderr, rejected) > 0 {
+			return 1
+		}
+	}
+
+	files, err := renderTemplates(tmpls, p.currentTree(), st)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	problems := 0
+	for _, f := range files {
+		if f.Name == composeTemplate {
+			for _, problem := range checkCompose([]byte(f.Content)) {
+				fmt.Fprintf(stderr, "%s: %s\n", filepath.Base(f.Output), problem)
+				problems++
+			}
+		}
+		if *out == "" {
+			if len(files) > 1 {
+				fmt.Fprintf(stdout, "# ==> %s <==\n", filepath.Base(f.Output))
+			}
+			fmt.Fprint(stdout, f.Content)
+			continue
+		}
+		rel, err := filepath.Rel(*dir, f.Output)
+		if err != nil {
+			rel = filepath.Base(f.Output)
+		}
+		target := filepath.Join(*out, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		// Rendered files hold plaintext passwords. WriteFile keeps the
+		// mode of an existing file, so files from an earlier render are
+		// restricted as well.
+		if err := os.WriteFile(target, []byte(f.Content), 0o600); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		if err := os.Chmod(target, 0o600); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+	}
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
+
+func cmdSchema(p *homeserverPlugin, args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 {
+		fmt.Fprintln(stderr, "schema takes no arguments")
+		return 2
+	}
+	if err := p.writeJSONSchema(stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	return 0
+}
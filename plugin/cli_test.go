@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runCLITest runs the CLI with args and returns its exit code and output.
+func runCLITest(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := runCLI(args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func TestCLIList(t *testing.T) {
+	code, out, _ := runCLITest(t, "list")
+	if code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	if lines := strings.Fields(out); len(lines) != len(valueDefs) {
+		t.Errorf("listed %d paths, want %d", len(lines), len(valueDefs))
+	}
+}
+
+func TestCLIGet(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"get", "pihole/web-port"}, 0, "8053\n"},
+		{[]string{"get", "-json", "core/timezone"}, 0, `"value": "Europe/Berlin"`},
+		{[]string{"get", "nope/nothing"}, 1, ""},
+		{[]string{"get"}, 2, ""},
+	}
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			code, out, _ := runCLITest(t, tt.args...)
+			if code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+			if !strings.Contains(out, tt.want) {
+				t.Errorf("output %q does not contain %q", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestCLIDefaults(t *testing.T) {
+	for _, format := range []string{"yaml", "json"} {
+		t.Run(format, func(t *testing.T) {
+			code, out, stderr := runCLITest(t, "defaults", "-format", format)
+			if code != 0 {
+				t.Fatalf("exit code %d: %s", code, stderr)
+			}
+			var got map[string]any
+			var err error
+			if format == "json" {
+				err = json.Unmarshal([]byte(out), &got)
+			} else {
+				err = yaml.Unmarshal([]byte(out), &got)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got["core/compose-project-name"] != "home-server" {
+				t.Errorf("core/compose-project-name = %v", got["core/compose-project-name"])
+			}
+			if _, ok := got["plex/url"]; ok {
+				t.Error("computed value in defaults")
+			}
+		})
+	}
+
+	t.Run("env", func(t *testing.T) {
+		_, out, _ := runCLITest(t, "defaults", "-format", "env")
+		for _, want := range []string{"NGINX_PROXY_MANAGER_ADMIN_PORT=81\n", "PIHOLE_UPSTREAM_DNS='1.1.1.1;8.8.8.8'\n", "CORE_DOMAIN=\n"} {
+			if !strings.Contains(out, want) {
+				t.Errorf("env output lacks %q", want)
+			}
+		}
+	})
+
+	if code, _, _ := runCLITest(t, "defaults", "-format", "toml"); code != 2 {
+		t.Errorf("unknown format exit code = %d, want 2", code)
+	}
+}
+
+func TestCLIValidate(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		f := filepath.Join(dir, name)
+		if err := os.WriteFile(f, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	// The defaults with the domain filled in and a DNS port that is free
+	// on any host are valid, so a file from the defaults command can be
+	// validated. Whether port 53 is free depends on the host.
+	_, defaults, _ := runCLITest(t, "defaults")
+	defaults = strings.NewReplacer(`core/domain: ""`, "core/domain: home.example.com", "pihole/dns-port: 53\n", "pihole/dns-port: 5300\n").Replace(defaults)
+	good := write("good.yaml", defaults)
+	if code, out, stderr := runCLITest(t, "validate", "-values", good); code != 0 {
+		t.Errorf("exit code %d: %s%s", code, out, stderr)
+	}
+
+	nested := write("nested.yaml", "core:\n  domain: home.example.com\npihole:\n  dns-port: 5300\n")
+	if code, out, _ := runCLITest(t, "validate", "-values", nested); code != 0 {
+		t.Errorf("nested file: exit code %d: %s", code, out)
+	}
+
+	bad := write("bad.yaml", "core/domain: home.example.com\npihole/dns-port: 5300\npihole/web-port: 80\nplex/url: x\nbogus/value: 1\n")
+	code, out, _ := runCLITest(t, "validate", "-values", bad)
+	if code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	for _, want := range []string{"pihole/web-port: Host port 80/tcp", "plex/url: plex/url is computed", "bogus/value: unknown config path", "4 blocking problem(s)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+
+	if code, _, _ := runCLITest(t, "validate"); code != 2 {
+		t.Errorf("missing -values exit code = %d, want 2", code)
+	}
+}
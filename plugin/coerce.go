@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coerceValue converts val to the Go type declared by d.Type. Safe
+// representations are accepted -- "8080" or 8080.0 for an int, "true" or
+// "yes" for a bool, a number for a string -- so that values arriving as
+// JSON over gRPC or as text from the CLI end up with the same Go type as
+// the defaults. Size values are additionally normalised to the notation
+// of d.SizeSyntax. Anything else is rejected.
+func coerceValue(d *ValueDef, val any) (any, error) {
+	if val == nil {
+		return nil, fmt.Errorf("value must not be empty (expected %s)", d.Type)
+	}
+	switch d.Type {
+	case "int":
+		n, ok := toInt(val)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a whole number", describeValue(val))
+		}
+		return n, nil
+	case "bool":
+		b, ok := toBool(val)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a boolean (use true or false)", describeValue(val))
+		}
+		return b, nil
+	case "string":
+		s, ok := toString(val)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a text value", describeValue(val))
+		}
+		return s, nil
+	case "size":
+		if n, ok := toInt(val); ok && n >= 0 {
+			return formatSize(int64(n), d.SizeSyntax), nil
+		}
+		s, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s is not a size", describeValue(val))
+		}
+		return normalizeSize(s, d.SizeSyntax)
+	default:
+		return nil, fmt.Errorf("unsupported value type %q", d.Type)
+	}
+}
+
+// toInt converts numeric config values to an int. Floats are accepted
+// when they have no fractional part, strings when they parse as an
+// integer.
+func toInt(v any) (int, bool) {
+	switch n := v.(type) {
+	case int:
+		return n, true
+	case int8:
+		return int(n), true
+	case int16:
+		return int(n), true
+	case int32:
+		return int(n), true
+	case int64:
+		return int(n), true
+	case uint:
+		return int(n), n <= math.MaxInt
+	case uint8:
+		return int(n), true
+	case uint16:
+		return int(n), true
+	case uint32:
+		return int(n), true
+	case uint64:
+		return int(n), n <= math.MaxInt
+	case float32:
+		return toInt(float64(n))
+	case float64:
+		if n != math.Trunc(n) || n > math.MaxInt || n < math.MinInt {
+			return 0, false
+		}
+		return int(n), true
+	case string:
+		i, err := strconv.Atoi(strings.TrimSpace(n))
+		if err != nil {
+			return 0, false
+		}
+		return i, true
+	}
+	return 0, false
+}
+
+// toBool converts booleans and their common textual spellings.
+func toBool(v any) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		switch strings.ToLower(strings.TrimSpace(b)) {
+		case "true", "yes", "on", "1":
+			return true, true
+		case "false", "no", "off", "0":
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// toString converts strings and scalar numbers or booleans to a string.
+// Numbers are formatted without a trailing ".0" so that an image tag
+// entered as 11 becomes "11".
+func toString(v any) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case bool:
+		return strconv.FormatBool(s), true
+	case float32:
+		return strconv.FormatFloat(float64(s), 'f', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64), true
+	}
+	if n, ok := toInt(v); ok {
+		return strconv.Itoa(n), true
+	}
+	return "", false
+}
+
+// describeValue renders a rejected value for error messages.
+func describeValue(v any) string {
+	return fmt.Sprintf("%#v (%T)", v, v)
+}
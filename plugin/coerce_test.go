@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		typ     string
+		syntax  string
+		val     any
+		want    any
+		wantErr bool
+	}{
+		{"int passes", "int", "", 8080, 8080, false},
+		{"int from float", "int", "", 8080.0, 8080, false},
+		{"int from string", "int", "", "8080", 8080, false},
+		{"int from padded string", "int", "", " 53 ", 53, false},
+		{"int rejects fraction", "int", "", 80.5, nil, true},
+		{"int rejects text", "int", "", "abc", nil, true},
+		{"int rejects bool", "int", "", true, nil, true},
+		{"bool passes", "bool", "", false, false, false},
+		{"bool from string", "bool", "", "true", true, false},
+		{"bool from yes", "bool", "", "Yes", true, false},
+		{"bool from off", "bool", "", "off", false, false},
+		{"bool rejects text", "bool", "", "maybe", nil, true},
+		{"bool rejects number", "bool", "", 1, nil, true},
+		{"string passes", "string", "", "hello", "hello", false},
+		{"string from int", "string", "", 11, "11", false},
+		{"string from float", "string", "", 11.0, "11", false},
+		{"string from bool", "string", "", true, "true", false},
+		{"string rejects list", "string", "", []any{"a"}, nil, true},
+		{"nil rejected", "string", "", nil, nil, true},
+		{"unknown type rejected", "float", "", 1.5, nil, true},
+		{"size for mariadb", "size", "mariadb", "256mb", "256M", false},
+		{"size for redis", "size", "redis", "128M", "128mb", false},
+		{"size for php", "size", "php", "16 GiB", "16G", false},
+		{"size from bytes", "size", "redis", 1048576, "1mb", false},
+		{"size empty stays empty", "size", "redis", "", "", false},
+		{"size rejects text", "size", "redis", "lots", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := coerceValue(&ValueDef{Type: tt.typ, SizeSyntax: tt.syntax}, tt.val)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %#v (%T), want %#v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllDefaultsMatchDeclaredType(t *testing.T) {
+	for _, d := range valueDefs {
+		got, err := coerceValue(&d, d.defaultValue(config.NewTree()))
+		if err != nil {
+			t.Errorf("path %q: default does not match type %q: %v", d.Path, d.Type, err)
+			continue
+		}
+		if got != d.defaultValue(config.NewTree()) {
+			t.Errorf("path %q: default %#v changes under coercion to %#v", d.Path, d.Default, got)
+		}
+	}
+}
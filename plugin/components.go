@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"github.com/MrWong99/zhi/pkg/zhiplugin/pluginopts"
+	"gopkg.in/yaml.v3"
+)
+
+// componentDef mirrors a component entry in the workspace's zhi.yaml.
+type componentDef struct {
+	Name         string   `yaml:"name"`
+	Paths        []string `yaml:"paths"`
+	Mandatory    bool     `yaml:"mandatory"`
+	Dependencies []string `yaml:"dependencies"`
+}
+
+// componentState holds the component definitions of the workspace and
+// which of them are enabled, as recorded by the zhi CLI.
+type componentState struct {
+	defs    []componentDef
+	enabled map[string]bool
+}
+
+// workspaceDir returns the directory of the zhi workspace the plugin
+// serves. It can be set through the "workspace-dir" plugin option or the
+// ZHI_WORKSPACE environment variable and defaults to the working
+// directory, which zhi inherits to the plugin process.
+func workspaceDir() string {
+	return pluginopts.String(pluginopts.Options(), "workspace-dir", "ZHI_WORKSPACE", ".")
+}
+
+// loadComponentState reads the component definitions from zhi.yaml and
+// the enabled state from .zhi/components.json in dir. Like zhi itself,
+// mandatory components are always enabled and components missing from
+// the state file start disabled. It returns fs.ErrNotExist if dir is not
+// a zhi workspace.
+func loadComponentState(dir string) (*componentState, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "zhi.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var ws struct {
+		Components []componentDef `yaml:"components"`
+	}
+	if err := yaml.Unmarshal(data, &ws); err != nil {
+		return nil, err
+	}
+
+	saved := map[string]bool{}
+	data, err = os.ReadFile(filepath.Join(dir, ".zhi", "components.json"))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, err
+		}
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, err
+	}
+
+	s := &componentState{defs: ws.Components, enabled: make(map[string]bool, len(ws.Components))}
+	for _, d := range ws.Components {
+		s.enabled[d.Name] = d.Mandatory || saved[d.Name]
+	}
+	return s, nil
+}
+
+// componentFiles are the files in a workspace the component state is read
+// from.
+var componentFiles = []string{"zhi.yaml", filepath.Join(".zhi", "components.json")}
+
+// fileStamp identifies a version of a file by its size and modification
+// time. A missing file has the zero stamp.
+type fileStamp struct {
+	size    int64
+	modTime int64 // nanoseconds since the Unix epoch
+}
+
+// componentCache keeps the component state of a workspace until one of
+// its componentFiles changes, so validating every path of a tree reads
+// them once instead of once per path.
+type componentCache struct {
+	mu     sync.Mutex
+	dir    string
+	stamps []fileStamp
+	state  *componentState
+	err    error
+}
+
+// load returns the component state of the workspace in dir, reading it
+// again only if dir or its files changed since the last call.
+func (c *componentCache) load(dir string) (*componentState, error) {
+	stamps := make([]fileStamp, len(componentFiles))
+	for i, name := range componentFiles {
+		if fi, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			stamps[i] = fileStamp{fi.Size(), fi.ModTime().UnixNano()}
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stamps == nil || c.dir != dir || !slices.Equal(c.stamps, stamps) {
+		c.state, c.err = loadComponentState(dir)
+		c.dir, c.stamps = dir, stamps
+	}
+	return c.state, c.err
+}
+
+// Enabled reports whether the named component is enabled.
+func (s *componentState) Enabled(name string) bool {
+	return s.enabled[name]
+}
+
+// componentOf returns the component owning path.
+func (s *componentState) componentOf(path string) (string, bool) {
+	for _, d := range s.defs {
+		for _, prefix := range d.Paths {
+			if strings.HasPrefix(path, prefix) {
+				return d.Name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// mandatory reports whether path belongs to a mandatory component, which
+// is enabled in every configuration. A nil state knows of none.
+func (s *componentState) mandatory(path string) bool {
+	if s == nil {
+		return false
+	}
+	for _, d := range s.defs {
+		if !d.Mandatory {
+			continue
+		}
+		for _, prefix := range d.Paths {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// componentTree is a TreeReader that also knows the component state of
+// the workspace, mirroring the ComponentEnabled function zhi offers to
+// templates.
+type componentTree struct {
+	config.TreeReader
+	state *componentState
+}
+
+// ComponentEnabled reports whether the named component is enabled.
+func (t componentTree) ComponentEnabled(name string) bool {
+	return t.state.Enabled(name)
+}
+
+// componentReader is implemented by trees that know which components are
+// enabled.
+type componentReader interface {
+	ComponentEnabled(name string) bool
+}
+
+// componentEnabled reports whether the named component is enabled. Trees
+// without component state fall back to checking whether the component
+// contributes any value to the tree, which holds for trees zhi filtered
+// by component.
+func componentEnabled(tree config.TreeReader, name string) bool {
+	if cr, ok := tree.(componentReader); ok {
+		return cr.ComponentEnabled(name)
+	}
+	prefix := name + "/"
+	for _, path := range tree.List() {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pathComponent returns the component owning path. Without workspace
+// state the first path segment is used, which matches the component
+// layout of this workspace.
+func pathComponent(tree config.TreeReader, path string) string {
+	if ct, ok := tree.(componentTree); ok {
+		if name, ok := ct.state.componentOf(path); ok {
+			return name
+		}
+		return ""
+	}
+	name, _, _ := strings.Cut(path, "/")
+	return name
+}
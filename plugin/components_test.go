@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// writeWorkspace creates a workspace directory with the repository's
+// zhi.yaml and the given component state.
+func writeWorkspace(t *testing.T, state string) string {
+	t.Helper()
+	dir := t.TempDir()
+	data, err := os.ReadFile(filepath.Join("..", "workspace", "zhi.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "zhi.yaml"), data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if state != "" {
+		if err := os.MkdirAll(filepath.Join(dir, ".zhi"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, ".zhi", "components.json"), []byte(state), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadComponentState(t *testing.T) {
+	dir := writeWorkspace(t, `{"plex": true, "pihole": false, "core": false}`)
+	st, err := loadComponentState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{
+		"core":                true, // mandatory
+		"plex":                true,
+		"pihole":              false,
+		"nextcloud":           false, // not in state file
+		"nginx-proxy-manager": false,
+	}
+	for name, enabled := range want {
+		if got := st.Enabled(name); got != enabled {
+			t.Errorf("Enabled(%q) = %v, want %v", name, got, enabled)
+		}
+	}
+	if c, ok := st.componentOf("nginx-proxy-manager/admin-port"); !ok || c != "nginx-proxy-manager" {
+		t.Errorf("componentOf = %q, %v", c, ok)
+	}
+}
+
+func TestLoadComponentStateWithoutStateFile(t *testing.T) {
+	st, err := loadComponentState(writeWorkspace(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !st.Enabled("core") || st.Enabled("plex") {
+		t.Errorf("expected only mandatory components enabled, got %v", st.enabled)
+	}
+}
+
+func TestLoadComponentStateNoWorkspace(t *testing.T) {
+	_, err := loadComponentState(t.TempDir())
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestPluginValidateSkipsDisabledComponents(t *testing.T) {
+	p := newHomeserverPlugin()
+	p.workspaceDir = writeWorkspace(t, `{"plex": true}`)
+	tree := newDefaultTree(t, nil)
+
+	// Plex-only: no service needs the domain or any of the passwords.
+	for _, path := range []string{
+		"core/domain",
+		"pihole/admin-password",
+		"nextcloud/admin-password",
+		"mariadb/root-password",
+		"mariadb/nextcloud-password",
+		"nginx-proxy-manager/letsencrypt-email",
+	} {
+		results, err := p.Validate(context.Background(), path, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 0 {
+			t.Errorf("Validate(%q) = %v, want no results for a Plex-only setup", path, results)
+		}
+	}
+}
+
+func TestPluginValidateEnabledComponentRules(t *testing.T) {
+	p := newHomeserverPlugin()
+	p.workspaceDir = writeWorkspace(t, `{"nginx-proxy-manager": true}`)
+	tree := newDefaultTree(t, nil)
+
+	for _, path := range []string{"core/domain", "nginx-proxy-manager/letsencrypt-email"} {
+		results, err := p.Validate(context.Background(), path, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 || results[0].Severity != config.Blocking {
+			t.Errorf("Validate(%q) = %v, want blocking result with NPM enabled", path, results)
+		}
+	}
+}
+
+func TestPortConflictsIgnoreDisabledComponents(t *testing.T) {
+	p := newHomeserverPlugin()
+	p.workspaceDir = writeWorkspace(t, `{"pihole": true}`)
+	tree := newDefaultTree(t, map[string]any{"pihole/web-port": 8080})
+
+	results, err := p.Validate(context.Background(), "pihole/web-port", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The security audit reports the admin port; only conflicts block.
+	for _, r := range results {
+		if r.Severity == config.Blocking {
+			t.Errorf("expected no conflict with disabled nextcloud, got %v", r)
+		}
+	}
+}
+
+func TestComponentCache(t *testing.T) {
+	dir := writeWorkspace(t, `{"pihole": true}`)
+	var c componentCache
+	first, err := c.load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.load(dir); again != first {
+		t.Error("unchanged workspace was read again")
+	}
+
+	// zhi component disable rewrites the state file.
+	if err := os.WriteFile(filepath.Join(dir, ".zhi", "components.json"), []byte(`{"pihole": false}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	st, err := c.load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == first || st.Enabled("pihole") {
+		t.Error("changed component state not read again")
+	}
+
+	if _, err := c.load(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without zhi.yaml")
+	}
+}
@@ -0,0 +1,516 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"gopkg.in/yaml.v3"
+)
+
+// composeTemplate is the name of the export template in zhi.yaml that
+// renders the Compose file.
+const composeTemplate = "docker-compose"
+
+// composePath is the config path that Compose file problems are reported
+// on. The file as a whole is the Compose project.
+const composePath = "core/compose-project-name"
+
+// keySet returns the set of the space-separated keys in s.
+func keySet(s string) map[string]bool {
+	set := map[string]bool{}
+	for _, k := range strings.Fields(s) {
+		set[k] = true
+	}
+	return set
+}
+
+// The keys the Compose specification allows. Keys starting with "x-" are
+// extensions and allowed everywhere an extension is.
+var (
+	composeTopLevelKeys = keySet(`version name include services networks volumes configs secrets models`)
+	composeServiceKeys  = keySet(`annotations attach blkio_config build cap_add cap_drop cgroup
+		cgroup_parent command configs container_name cpu_count cpu_percent cpu_period cpu_quota
+		cpu_rt_period cpu_rt_runtime cpu_shares cpus cpuset credential_spec depends_on deploy
+		develop device_cgroup_rules devices dns dns_opt dns_search domainname driver_opts
+		entrypoint env_file environment expose extends external_links extra_hosts gpus group_add
+		healthcheck hostname image init ipc isolation label_file labels links logging mac_address
+		mem_limit mem_reservation mem_swappiness memswap_limit models network_mode networks
+		oom_kill_disable oom_score_adj pid pids_limit platform ports post_start pre_stop
+		privileged profiles provider pull_policy read_only restart runtime scale secrets
+		security_opt shm_size stdin_open stop_grace_period stop_signal storage_opt sysctls tmpfs
+		tty ulimits use_api_socket user userns_mode uts volumes volumes_from working_dir`)
+	composeHealthcheckKeys    = keySet(`disable interval retries start_interval start_period test timeout`)
+	composeDependsOnKeys      = keySet(`condition required restart`)
+	composeDependsOnConds     = keySet(`service_started service_healthy service_completed_successfully`)
+	composeNetworkKeys        = keySet(`attachable driver driver_opts enable_ipv4 enable_ipv6 external internal ipam labels name`)
+	composeServiceNetworkKeys = keySet(`aliases driver_opts gw_priority interface_name ipv4_address ipv6_address link_local_ips mac_address priority`)
+	composeVolumeKeys         = keySet(`driver driver_opts external labels name`)
+	composeServiceVolumeKeys  = keySet(`bind consistency image read_only source target tmpfs type volume`)
+	composePortKeys           = keySet(`app_protocol host_ip mode name protocol published target`)
+	composePortProtocols      = keySet(`tcp udp sctp`)
+)
+
+// composeProblem is a structural error in a Compose file. Line is 0 for
+// problems that concern the file as a whole.
+type composeProblem struct {
+	Line    int
+	Message string
+}
+
+func (p composeProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// composeChecker collects the problems of one Compose file.
+type composeChecker struct {
+	problems []composeProblem
+
+	networks map[string]bool
+	volumes  map[string]bool
+	services map[string]bool
+
+	// containers maps container names to the first service using them.
+	containers map[string]string
+	// dependsOn holds the depends_on entries of all services, which can
+	// only be checked once every service is known.
+	dependsOn []composeDependency
+}
+
+type composeDependency struct {
+	service, on string
+	line        int
+}
+
+// checkCompose parses a Compose file and returns its structural problems:
+// keys the Compose specification does not know, references to undefined
+// networks, volumes and services, duplicate container names and port
+// mappings Compose cannot parse. It does not contact Docker.
+func checkCompose(content []byte) []composeProblem {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return []composeProblem{{Message: err.Error()}}
+	}
+	if len(doc.Content) == 0 {
+		return []composeProblem{{Message: "the file is empty"}}
+	}
+	c := &composeChecker{
+		networks:   map[string]bool{"default": true},
+		volumes:    map[string]bool{},
+		services:   map[string]bool{},
+		containers: map[string]string{},
+	}
+	c.checkFile(doc.Content[0])
+	return c.problems
+}
+
+func (c *composeChecker) report(n *yaml.Node, format string, args ...any) {
+	c.problems = append(c.problems, composeProblem{Line: n.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+// mapping returns the key and value nodes of the mapping n, reporting
+// duplicate keys. A null node is an empty mapping; any other node is
+// reported as what.
+func (c *composeChecker) mapping(n *yaml.Node, what string) [][2]*yaml.Node {
+	n = resolveAlias(n)
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+	if n.Kind != yaml.MappingNode {
+		c.report(n, "%s must be a mapping", what)
+		return nil
+	}
+	seen := map[string]bool{}
+	var pairs [][2]*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i], resolveAlias(n.Content[i+1])
+		if k.Value == "<<" {
+			// Merge keys are resolved by Compose; their content is not
+			// checked.
+			continue
+		}
+		if seen[k.Value] {
+			c.report(k, "%s has %q more than once", what, k.Value)
+		}
+		seen[k.Value] = true
+		pairs = append(pairs, [2]*yaml.Node{k, v})
+	}
+	return pairs
+}
+
+// sequence returns the items of the sequence n. A null node is an empty
+// sequence; any other node is reported as what.
+func (c *composeChecker) sequence(n *yaml.Node, what string) []*yaml.Node {
+	n = resolveAlias(n)
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+	if n.Kind != yaml.SequenceNode {
+		c.report(n, "%s must be a list", what)
+		return nil
+	}
+	items := make([]*yaml.Node, len(n.Content))
+	for i, item := range n.Content {
+		items[i] = resolveAlias(item)
+	}
+	return items
+}
+
+// checkKeys reports the keys of pairs that are not in allowed. Extension
+// keys are allowed if extensions is set.
+func (c *composeChecker) checkKeys(pairs [][2]*yaml.Node, allowed map[string]bool, extensions bool, what string) {
+	for _, kv := range pairs {
+		k := kv[0].Value
+		if allowed[k] || extensions && strings.HasPrefix(k, "x-") {
+			continue
+		}
+		c.report(kv[0], "unknown key %q in %s", k, what)
+	}
+}
+
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	return n
+}
+
+func (c *composeChecker) checkFile(root *yaml.Node) {
+	pairs := c.mapping(root, "the file")
+	c.checkKeys(pairs, composeTopLevelKeys, true, "the file")
+
+	// Networks and volumes are collected first, so services can refer to
+	// them regardless of the order of the sections.
+	var services *yaml.Node
+	for _, kv := range pairs {
+		switch kv[0].Value {
+		case "networks":
+			for _, nkv := range c.mapping(kv[1], "networks") {
+				c.networks[nkv[0].Value] = true
+				c.checkKeys(c.mapping(nkv[1], "network "+nkv[0].Value), composeNetworkKeys, true, "network "+nkv[0].Value)
+			}
+		case "volumes":
+			for _, vkv := range c.mapping(kv[1], "volumes") {
+				c.volumes[vkv[0].Value] = true
+				c.checkKeys(c.mapping(vkv[1], "volume "+vkv[0].Value), composeVolumeKeys, true, "volume "+vkv[0].Value)
+			}
+		case "services":
+			services = kv[1]
+		}
+	}
+	if services == nil {
+		c.report(root, "the file defines no services")
+		return
+	}
+	svcs := c.mapping(services, "services")
+	for _, kv := range svcs {
+		c.services[kv[0].Value] = true
+	}
+	for _, kv := range svcs {
+		c.checkService(kv[0].Value, kv[1])
+	}
+	for _, d := range c.dependsOn {
+		if !c.services[d.on] {
+			c.problems = append(c.problems, composeProblem{
+				Line:    d.line,
+				Message: fmt.Sprintf("service %s depends on %s, which is not defined. Is its component disabled?", d.service, d.on),
+			})
+		}
+	}
+}
+
+func (c *composeChecker) checkService(name string, n *yaml.Node) {
+	what := "service " + name
+	pairs := c.mapping(n, what)
+	c.checkKeys(pairs, composeServiceKeys, true, what)
+
+	fields := map[string]*yaml.Node{}
+	for _, kv := range pairs {
+		fields[kv[0].Value] = kv[1]
+	}
+	if fields["image"] == nil && fields["build"] == nil {
+		c.report(n, "%s has neither an image nor a build section", what)
+	}
+	if img := fields["image"]; img != nil && (img.Value == "" || strings.HasSuffix(img.Value, ":")) {
+		c.report(img, "%s has an empty image reference %q", what, img.Value)
+	}
+	if cn := fields["container_name"]; cn != nil {
+		if other, ok := c.containers[cn.Value]; ok {
+			c.report(cn, "%s uses container name %q, which service %s uses too", what, cn.Value, other)
+		} else {
+			c.containers[cn.Value] = name
+		}
+	}
+	if fields["network_mode"] != nil && fields["networks"] != nil {
+		c.report(fields["networks"], "%s sets both network_mode and networks", what)
+	}
+	if ports := fields["ports"]; ports != nil {
+		for _, p := range c.sequence(ports, what+" ports") {
+			c.checkPort(what, p)
+		}
+	}
+	if nets := fields["networks"]; nets != nil {
+		c.checkServiceNetworks(what, nets)
+	}
+	if vols := fields["volumes"]; vols != nil {
+		for _, v := range c.sequence(vols, what+" volumes") {
+			c.checkServiceVolume(what, v)
+		}
+	}
+	if deps := fields["depends_on"]; deps != nil {
+		c.checkDependsOn(name, deps)
+	}
+	if hc := fields["healthcheck"]; hc != nil {
+		c.checkKeys(c.mapping(hc, what+" healthcheck"), composeHealthcheckKeys, true, what+" healthcheck")
+	}
+}
+
+func (c *composeChecker) checkPort(what string, n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		if err := checkPortSpec(n.Value); err != nil {
+			c.report(n, "%s has an invalid port mapping %q: %v", what, n.Value, err)
+		}
+	case yaml.MappingNode:
+		pairs := c.mapping(n, what+" port")
+		c.checkKeys(pairs, composePortKeys, true, what+" port")
+		hasTarget := false
+		for _, kv := range pairs {
+			switch kv[0].Value {
+			case "target":
+				hasTarget = true
+				if _, err := parsePortRange(kv[1].Value); err != nil {
+					c.report(kv[1], "%s has an invalid target port %q: %v", what, kv[1].Value, err)
+				}
+			case "published":
+				if _, err := parsePortRange(kv[1].Value); err != nil {
+					c.report(kv[1], "%s has an invalid published port %q: %v", what, kv[1].Value, err)
+				}
+			case "protocol":
+				if !composePortProtocols[kv[1].Value] {
+					c.report(kv[1], "%s has an unknown port protocol %q", what, kv[1].Value)
+				}
+			case "host_ip":
+				if _, err := netip.ParseAddr(kv[1].Value); err != nil {
+					c.report(kv[1], "%s has an invalid host_ip %q", what, kv[1].Value)
+				}
+			}
+		}
+		if !hasTarget {
+			c.report(n, "%s has a port without a target", what)
+		}
+	default:
+		c.report(n, "%s has a port mapping that is neither a string nor a mapping", what)
+	}
+}
+
+// checkPortSpec checks the short port syntax of Compose:
+// [[HOST_IP:]HOST_PORT:]CONTAINER_PORT[/PROTOCOL], where ports can be
+// ranges such as 8000-8010 and an IPv6 HOST_IP is written in brackets.
+func checkPortSpec(spec string) error {
+	rest, proto, hasProto := strings.Cut(spec, "/")
+	if hasProto && !composePortProtocols[proto] {
+		return fmt.Errorf("unknown protocol %q", proto)
+	}
+	if strings.HasPrefix(rest, "[") {
+		ip, after, ok := strings.Cut(rest[1:], "]:")
+		if !ok {
+			return fmt.Errorf("unterminated IPv6 address")
+		}
+		if addr, err := netip.ParseAddr(ip); err != nil || !addr.Is6() {
+			return fmt.Errorf("invalid IPv6 address %q", ip)
+		}
+		host, container, ok := strings.Cut(after, ":")
+		if !ok {
+			return fmt.Errorf("a host IP needs a container port")
+		}
+		return checkHostContainer(host, container, true)
+	}
+	parts := strings.Split(rest, ":")
+	switch len(parts) {
+	case 1:
+		_, err := parsePortRange(parts[0])
+		return err
+	case 2:
+		return checkHostContainer(parts[0], parts[1], false)
+	case 3:
+		if _, err := netip.ParseAddr(parts[0]); err != nil {
+			return fmt.Errorf("invalid host IP %q", parts[0])
+		}
+		return checkHostContainer(parts[1], parts[2], true)
+	default:
+		return fmt.Errorf("too many colons")
+	}
+}
+
+// checkHostContainer checks the host and container ports of a mapping.
+// The host port may be empty after a host IP, which publishes on a random
+// port.
+func checkHostContainer(host, container string, hostOptional bool) error {
+	if host != "" || !hostOptional {
+		if _, err := parsePortRange(host); err != nil {
+			return fmt.Errorf("host port: %w", err)
+		}
+	}
+	if _, err := parsePortRange(container); err != nil {
+		return fmt.Errorf("container port: %w", err)
+	}
+	return nil
+}
+
+// parsePortRange parses a port such as 80 or a range such as 8000-8010 and
+// returns the number of ports in it.
+func parsePortRange(s string) (int, error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	from, err := parsePort(lo)
+	if err != nil {
+		return 0, err
+	}
+	to, err := parsePort(hi)
+	if err != nil {
+		return 0, err
+	}
+	if to < from {
+		return 0, fmt.Errorf("range %s is reversed", s)
+	}
+	return to - from + 1, nil
+}
+
+func parsePort(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > 65535 {
+		return 0, fmt.Errorf("%q is not a port between 1 and 65535", s)
+	}
+	return n, nil
+}
+
+func (c *composeChecker) checkServiceNetworks(what string, n *yaml.Node) {
+	if n.Kind == yaml.SequenceNode {
+		for _, item := range c.sequence(n, what+" networks") {
+			c.checkNetworkRef(what, item)
+		}
+		return
+	}
+	for _, kv := range c.mapping(n, what+" networks") {
+		c.checkNetworkRef(what, kv[0])
+		c.checkKeys(c.mapping(kv[1], what+" network "+kv[0].Value), composeServiceNetworkKeys, true, what+" network "+kv[0].Value)
+	}
+}
+
+func (c *composeChecker) checkNetworkRef(what string, n *yaml.Node) {
+	if !c.networks[n.Value] {
+		c.report(n, "%s uses network %q, which is not defined under networks", what, n.Value)
+	}
+}
+
+func (c *composeChecker) checkServiceVolume(what string, n *yaml.Node) {
+	switch n.Kind {
+	case yaml.ScalarNode:
+		parts := strings.Split(n.Value, ":")
+		if len(parts) > 3 || slices.Contains(parts, "") {
+			c.report(n, "%s has an invalid volume %q", what, n.Value)
+			return
+		}
+		if len(parts) == 1 {
+			// An anonymous volume.
+			return
+		}
+		c.checkVolumeRef(what, n, parts[0])
+	case yaml.MappingNode:
+		pairs := c.mapping(n, what+" volume")
+		c.checkKeys(pairs, composeServiceVolumeKeys, true, what+" volume")
+		fields := map[string]*yaml.Node{}
+		for _, kv := range pairs {
+			fields[kv[0].Value] = kv[1]
+		}
+		if fields["target"] == nil {
+			c.report(n, "%s has a volume without a target", what)
+		}
+		if t := fields["type"]; t != nil && t.Value == "volume" && fields["source"] != nil {
+			c.checkVolumeRef(what, fields["source"], fields["source"].Value)
+		}
+	default:
+		c.report(n, "%s has a volume that is neither a string nor a mapping", what)
+	}
+}
+
+// checkVolumeRef reports source if it is empty or names a volume that is
+// not defined. Sources that look like paths are bind mounts.
+func (c *composeChecker) checkVolumeRef(what string, n *yaml.Node, source string) {
+	if source == "" {
+		c.report(n, "%s has a volume without a source", what)
+		return
+	}
+	if strings.ContainsAny(source[:1], "/.~$") || c.volumes[source] {
+		return
+	}
+	c.report(n, "%s uses volume %q, which is not defined under volumes", what, source)
+}
+
+func (c *composeChecker) checkDependsOn(service string, n *yaml.Node) {
+	what := "service " + service + " depends_on"
+	if n.Kind == yaml.SequenceNode {
+		for _, item := range c.sequence(n, what) {
+			c.dependsOn = append(c.dependsOn, composeDependency{service: service, on: item.Value, line: item.Line})
+		}
+		return
+	}
+	for _, kv := range c.mapping(n, what) {
+		c.dependsOn = append(c.dependsOn, composeDependency{service: service, on: kv[0].Value, line: kv[0].Line})
+		pairs := c.mapping(kv[1], what+" "+kv[0].Value)
+		c.checkKeys(pairs, composeDependsOnKeys, false, what+" "+kv[0].Value)
+		for _, dkv := range pairs {
+			if dkv[0].Value == "condition" && !composeDependsOnConds[dkv[1].Value] {
+				c.report(dkv[1], "%s has an unknown condition %q", what, dkv[1].Value)
+			}
+		}
+	}
+}
+
+// validateCompose renders the workspace's Compose file with tree and
+// reports its structural problems as Blocking results on composePath,
+// followed by the secrets it contains (see auditComposeSecrets).
+// Outside a workspace, or without a Compose template, there is nothing to
+// check.
+func (p *homeserverPlugin) validateCompose(path string, tree config.TreeReader) ([]config.ValidationResult, error) {
+	if path != composePath {
+		return nil, nil
+	}
+	st, err := p.componentState()
+	if err != nil {
+		return nil, nil
+	}
+	tmpls, err := loadExportTemplates(p.workspaceDir)
+	if err != nil {
+		return nil, nil
+	}
+	i := slices.IndexFunc(tmpls, func(t exportTemplate) bool { return t.Name == composeTemplate })
+	if i < 0 {
+		return nil, nil
+	}
+	files, err := renderTemplates(tmpls[i:i+1], tree, st)
+	if err != nil {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("The Compose file cannot be rendered: %v", err),
+			Severity: config.Blocking,
+		}}, nil
+	}
+	var results []config.ValidationResult
+	for _, problem := range checkCompose([]byte(files[0].Content)) {
+		results = append(results, config.ValidationResult{
+			Message:  filepath.Base(files[0].Output) + ": " + problem.String(),
+			Severity: config.Blocking,
+		})
+	}
+	return append(results, auditComposeSecrets(files[0])...), nil
+}
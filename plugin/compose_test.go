@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+const validCompose = `
+networks:
+  frontend:
+volumes:
+  data:
+services:
+  app:
+    image: nginx:1
+    container_name: app
+    ports:
+      - "8080:80"
+      - "127.0.0.1:8443:443/tcp"
+      - "[::1]::53/udp"
+      - target: 81
+        published: "8081"
+    volumes:
+      - data:/data
+      - /srv/app:/srv
+      - ./conf:/etc/conf:ro
+    networks:
+      - frontend
+    depends_on:
+      db:
+        condition: service_healthy
+    x-note: extension keys are allowed
+  db:
+    image: mariadb:11
+    healthcheck:
+      test: ["CMD", "true"]
+`
+
+func TestCheckCompose(t *testing.T) {
+	tests := []struct {
+		name    string
+		compose string
+		want    string // substring of the only problem, "" for none
+	}{
+		{"valid file", validCompose, ""},
+		{"unknown top-level key", "netwroks:\n  n:\nservices:\n  app:\n    image: a:1\n", `unknown key "netwroks" in the file`},
+		{"unknown service key", "services:\n  app:\n    image: a:1\n    port: [\"80\"]\n", `unknown key "port" in service app`},
+		{"unknown healthcheck key", "services:\n  app:\n    image: a:1\n    healthcheck:\n      command: true\n", `unknown key "command" in service app healthcheck`},
+		{"undefined network", "services:\n  app:\n    image: a:1\n    networks: [backend]\n", `network "backend", which is not defined`},
+		{"undefined volume", "services:\n  app:\n    image: a:1\n    volumes: [\"db:/data\"]\n", `volume "db", which is not defined`},
+		{"volume without source", "services:\n  app:\n    image: a:1\n    volumes:\n      - type: volume\n        source: \"\"\n        target: /data\n", "volume without a source"},
+		{"depends on missing service", "services:\n  app:\n    image: a:1\n    depends_on: [db]\n", "depends on db, which is not defined"},
+		{"unknown condition", "services:\n  app:\n    image: a:1\n    depends_on:\n      app2:\n        condition: healthy\n  app2:\n    image: a:1\n", `unknown condition "healthy"`},
+		{"duplicate container name", "services:\n  a:\n    image: a:1\n    container_name: x\n  b:\n    image: a:1\n    container_name: x\n", `container name "x", which service a uses too`},
+		{"duplicate key", "services:\n  a:\n    image: a:1\n    image: a:2\n", `has "image" more than once`},
+		{"empty image tag", "services:\n  a:\n    image: 'pihole/pihole:'\n", "empty image reference"},
+		{"no image", "services:\n  a:\n    restart: always\n", "neither an image nor a build"},
+		{"bad protocol", "services:\n  a:\n    image: a:1\n    ports: [\"53:53/tpc\"]\n", `unknown protocol "tpc"`},
+		{"port out of range", "services:\n  a:\n    image: a:1\n    ports: [\"70000:80\"]\n", "host port"},
+		{"empty host port", "services:\n  a:\n    image: a:1\n    ports: [\":80\"]\n", "host port"},
+		{"bad host ip", "services:\n  a:\n    image: a:1\n    ports: [\"300.1.1.1:80:80\"]\n", "invalid host IP"},
+		{"reversed range", "services:\n  a:\n    image: a:1\n    ports: [\"90-80:90-80\"]\n", "reversed"},
+		{"network_mode with networks", "networks:\n  n:\nservices:\n  a:\n    image: a:1\n    network_mode: host\n    networks: [n]\n", "both network_mode and networks"},
+		{"invalid yaml", "services:\n  a: [\n", "yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := checkCompose([]byte(tt.compose))
+			if tt.want == "" {
+				if len(problems) > 0 {
+					t.Errorf("unexpected problems: %v", problems)
+				}
+				return
+			}
+			if len(problems) != 1 || !strings.Contains(problems[0].Message, tt.want) {
+				t.Errorf("problems = %v, want one containing %q", problems, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckComposeLine(t *testing.T) {
+	problems := checkCompose([]byte("services:\n  app:\n    image: a:1\n    networks: [backend]\n"))
+	if len(problems) != 1 || problems[0].Line != 4 {
+		t.Fatalf("problems = %v, want one on line 4", problems)
+	}
+	if got := problems[0].String(); !strings.HasPrefix(got, "line 4: ") {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+// writeTemplateWorkspace creates a workspace with the repository's zhi.yaml
+// and templates and the given component state.
+func writeTemplateWorkspace(t *testing.T, state string) string {
+	t.Helper()
+	dir := writeWorkspace(t, state)
+	if err := os.CopyFS(filepath.Join(dir, "templates"), os.DirFS(filepath.Join("..", "workspace", "templates"))); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestValidateCompose(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    string
+		blocking string // substring of the expected blocking result
+	}{
+		{"all services", `{"pihole": true, "plex": true, "nextcloud": true, "mariadb": true, "redis": true, "nginx-proxy-manager": true}`, ""},
+		{"core only", "", ""},
+		{"nextcloud without mariadb", `{"nextcloud": true, "redis": true}`, "depends on mariadb, which is not defined"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newHomeserverPlugin()
+			p.workspaceDir = writeTemplateWorkspace(t, tt.state)
+			results, err := p.Validate(context.Background(), composePath, p.currentTree())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var blocking []string
+			for _, r := range results {
+				if r.Severity == config.Blocking {
+					blocking = append(blocking, r.Message)
+				}
+			}
+			if tt.blocking == "" {
+				if len(blocking) > 0 {
+					t.Errorf("unexpected blocking results: %v", blocking)
+				}
+				return
+			}
+			if len(blocking) != 1 || !strings.Contains(blocking[0], tt.blocking) {
+				t.Errorf("blocking results = %v, want one containing %q", blocking, tt.blocking)
+			}
+			if !strings.HasPrefix(blocking[0], "docker-compose.yml: line ") {
+				t.Errorf("result %q does not name the file and line", blocking[0])
+			}
+		})
+	}
+}
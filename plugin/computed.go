@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// Compute functions for read-only values. They receive the plugin's
+// values or, in the transform, the merged tree, wrapped with the
+// workspace component state.
+
+// computeFuncs holds the compute functions the compute field of
+// values.yaml can refer to. Each entry returns the function for the
+// component that owns the value.
+var computeFuncs = map[string]func(component string) func(config.TreeReader) any{
+	"url":            urlOf,
+	"total-memory":   func(string) func(config.TreeReader) any { return computeTotalMemory },
+	"exposed-ports":  func(string) func(config.TreeReader) any { return computeExposedPorts },
+	"overwrite-host": func(string) func(config.TreeReader) any { return computeOverwriteHost },
+}
+
+// computeTotalMemory returns the memory reserved by the enabled
+// components, or "" if none reserves any.
+func computeTotalMemory(tree config.TreeReader) any {
+	total, _ := configuredMemory(tree)
+	if total == 0 {
+		return ""
+	}
+	return formatSize(total, "")
+}
+
+// computeExposedPorts returns the host ports of the enabled components as
+// a comma-separated list, e.g. "53/tcp,53/udp,8053/tcp".
+func computeExposedPorts(tree config.TreeReader) any {
+	return strings.Join(exposedPorts(tree), ",")
+}
+
+// computeOverwriteHost returns the host (and port, if not the scheme's
+// default) clients use to reach Nextcloud, for its overwritehost
+// setting. It is "" while no domain is set, leaving Nextcloud to use the
+// host of each request.
+func computeOverwriteHost(tree config.TreeReader) any {
+	if !componentEnabled(tree, "nextcloud") || treeString(tree, "core/domain") == "" {
+		return ""
+	}
+	if httpsEnabled(tree) {
+		return serviceHost(tree, "nextcloud") + portSuffix(tree, "nginx-proxy-manager/https-port", 443)
+	}
+	return treeString(tree, "core/domain") + portSuffix(tree, "nextcloud/web-port", 80)
+}
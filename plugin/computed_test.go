@@ -0,0 +1,96 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestComputedValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     string
+		overrides map[string]any
+		compute   func(config.TreeReader) any
+		want      any
+	}{
+		{
+			name:    "total memory of mariadb and redis",
+			state:   `{"mariadb": true, "redis": true}`,
+			compute: computeTotalMemory,
+			want:    "384M",
+		},
+		{
+			name:    "total memory skips disabled components",
+			state:   `{"redis": true}`,
+			compute: computeTotalMemory,
+			want:    "128M",
+		},
+		{
+			name:    "no memory consumers",
+			state:   `{}`,
+			compute: computeTotalMemory,
+			want:    "",
+		},
+		{
+			name:    "exposed ports",
+			state:   `{"pihole": true, "nextcloud": true}`,
+			compute: computeExposedPorts,
+			want:    "53/tcp,53/udp,8053/tcp,8080/tcp",
+		},
+		{
+			name:    "overwrite host without domain",
+			state:   `{"nextcloud": true}`,
+			compute: computeOverwriteHost,
+			want:    "",
+		},
+		{
+			name:      "overwrite host on direct port",
+			state:     `{"nextcloud": true}`,
+			overrides: map[string]any{"core/domain": "home.example.com"},
+			compute:   computeOverwriteHost,
+			want:      "home.example.com:8080",
+		},
+		{
+			name:      "overwrite host on port 80",
+			state:     `{"nextcloud": true}`,
+			overrides: map[string]any{"core/domain": "home.example.com", "nextcloud/web-port": 80},
+			compute:   computeOverwriteHost,
+			want:      "home.example.com",
+		},
+		{
+			name:      "overwrite host behind proxy",
+			state:     `{"nextcloud": true, "nginx-proxy-manager": true}`,
+			overrides: map[string]any{"core/domain": "home.example.com"},
+			compute:   computeOverwriteHost,
+			want:      "cloud.home.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := loadComponentState(writeWorkspace(t, tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree := componentTree{TreeReader: newDefaultTree(t, tt.overrides), state: st}
+			if got := tt.compute(tree); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputedValuesAreReadOnly(t *testing.T) {
+	for _, d := range valueDefs {
+		if d.Compute == nil {
+			continue
+		}
+		v := d.ToValue()
+		if v.Metadata["ui.readonly"] != true || v.Metadata["store.ephemeral"] != true {
+			t.Errorf("%s: computed value is missing read-only metadata: %v", d.Path, v.Metadata)
+		}
+		if d.Required || d.Password || d.AutoGenerate {
+			t.Errorf("%s: computed value cannot be required or a password", d.Path)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// Derived is a ValueDef.Default computed from other values. It is
+// resolved through a config.TreeReader whenever the default is read, so
+// the value follows its sources until the user overrides it.
+type Derived struct {
+	Sources []string                         // paths the default is computed from (config.derivedFrom)
+	Resolve func(tree config.TreeReader) any // computes the default from the current values
+}
+
+// defaultValue returns the default of d, resolving a Derived default
+// against tree.
+func (d *ValueDef) defaultValue(tree config.TreeReader) any {
+	if dd, ok := d.Default.(Derived); ok {
+		return dd.Resolve(tree)
+	}
+	return d.Default
+}
+
+// treeString returns the value at path as a string, or "" if it is unset.
+func treeString(tree config.TreeReader, path string) string {
+	v, ok := tree.Get(path)
+	if !ok {
+		return ""
+	}
+	s, _ := toString(v.Val)
+	return s
+}
+
+// deriveFuncs holds the derived defaults the derive field of values.yaml
+// can refer to, keyed by the value they derive from.
+var deriveFuncs = map[string]func(component, format, fallback string) Derived{
+	"domain":       func(_, format, fallback string) Derived { return fromDomain(format, fallback) },
+	"service-host": fromServiceHost,
+	"served-hosts": fromServedHosts,
+}
+
+// fromDomain returns a Derived default that inserts core/domain into
+// format, or yields fallback while no domain is set.
+func fromDomain(format, fallback string) Derived {
+	return Derived{
+		Sources: []string{"core/domain"},
+		Resolve: func(tree config.TreeReader) any {
+			domain := treeString(tree, "core/domain")
+			if domain == "" {
+				return fallback
+			}
+			return fmt.Sprintf(format, domain)
+		},
+	}
+}
+
+// fromServiceHost returns a Derived default that inserts the public host
+// of component (see serviceHost) into format, or yields fallback while no
+// domain is set.
+func fromServiceHost(component, format, fallback string) Derived {
+	return Derived{
+		Sources: []string{"core/domain", component + "/subdomain"},
+		Resolve: func(tree config.TreeReader) any {
+			host := serviceHost(tree, component)
+			if host == "" {
+				return fallback
+			}
+			return fmt.Sprintf(format, host)
+		},
+	}
+}
+
+// fromServedHosts returns a Derived default that inserts the hosts
+// component is served on into format: core/domain, on which it is reached
+// directly, and its public host behind Nginx Proxy Manager if that
+// differs (see serviceHost), separated by a space. It yields fallback
+// while no domain is set.
+func fromServedHosts(component, format, fallback string) Derived {
+	return Derived{
+		Sources: []string{"core/domain", component + "/subdomain"},
+		Resolve: func(tree config.TreeReader) any {
+			domain := treeString(tree, "core/domain")
+			if domain == "" {
+				return fallback
+			}
+			hosts := domain
+			if host := serviceHost(tree, component); host != domain {
+				hosts += " " + host
+			}
+			return fmt.Sprintf(format, hosts)
+		},
+	}
+}
+
+// pluginTree is a TreeReader over the plugin's own values, used to
+// resolve derived defaults. The caller must hold the plugin's lock.
+type pluginTree struct {
+	p *homeserverPlugin
+}
+
+// Get returns the effective value at path.
+func (t pluginTree) Get(path string) (config.Value, bool) {
+	return t.p.getLocked(path)
+}
+
+// List returns all paths the plugin serves.
+func (t pluginTree) List() []string {
+	return t.p.paths
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestDerivedDefaults(t *testing.T) {
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+	get := func(path string) any {
+		t.Helper()
+		v, ok, err := p.Get(ctx, path)
+		if err != nil || !ok {
+			t.Fatalf("Get(%s) found=%v err=%v", path, ok, err)
+		}
+		return v.Val
+	}
+	set := func(path string, val any) {
+		t.Helper()
+		if err := p.Set(ctx, path, config.Value{Val: val}); err != nil {
+			t.Fatalf("Set(%s): %v", path, err)
+		}
+	}
+
+	if got := get("nextcloud/trusted-domains"); got != "localhost" {
+		t.Errorf("trusted-domains without domain = %q, want localhost", got)
+	}
+	if got := get("nginx-proxy-manager/letsencrypt-email"); got != "" {
+		t.Errorf("letsencrypt-email without domain = %q, want empty", got)
+	}
+
+	set("core/domain", "home.example.com")
+	if got := get("nextcloud/trusted-domains"); got != "home.example.com cloud.home.example.com localhost" {
+		t.Errorf("trusted-domains = %q", got)
+	}
+	if got := get("nginx-proxy-manager/letsencrypt-email"); got != "admin@home.example.com" {
+		t.Errorf("letsencrypt-email = %q", got)
+	}
+
+	// Storing the derived value as-is keeps it following the domain.
+	set("nextcloud/trusted-domains", "home.example.com cloud.home.example.com localhost")
+	set("core/domain", "example.org")
+	if got := get("nextcloud/trusted-domains"); got != "example.org cloud.example.org localhost" {
+		t.Errorf("trusted-domains after domain change = %q", got)
+	}
+
+	// An explicit value overrides the derivation.
+	set("nginx-proxy-manager/letsencrypt-email", "me@mail.example.net")
+	set("core/domain", "example.com")
+	if got := get("nginx-proxy-manager/letsencrypt-email"); got != "me@mail.example.net" {
+		t.Errorf("overridden letsencrypt-email = %q", got)
+	}
+
+	// Clearing the value makes it follow its source again.
+	set("nginx-proxy-manager/letsencrypt-email", "")
+	if got := get("nginx-proxy-manager/letsencrypt-email"); got != "admin@example.com" {
+		t.Errorf("reset letsencrypt-email = %q", got)
+	}
+
+}
+
+func TestDerivedDefaultMetadata(t *testing.T) {
+	for _, d := range valueDefs {
+		dd, ok := d.Default.(Derived)
+		v := d.ToValue()
+		sources, has := v.Metadata["config.derivedFrom"].([]string)
+		if ok != has {
+			t.Errorf("%s: config.derivedFrom present = %v, want %v", d.Path, has, ok)
+			continue
+		}
+		if ok && !slices.Equal(sources, dd.Sources) {
+			t.Errorf("%s: config.derivedFrom = %v, want %v", d.Path, sources, dd.Sources)
+		}
+	}
+}
+
+func TestDerivedDefaultsInTransform(t *testing.T) {
+	t.Setenv("ZHI_WORKSPACE", writeWorkspace(t, `{"nextcloud": true, "nginx-proxy-manager": true}`))
+	p := newHomeserverPlugin()
+	tests := []struct {
+		name  string
+		saved map[string]any
+		path  string
+		want  string
+	}{
+		{"unsaved value follows the saved domain", map[string]any{"core/domain": "example.org"}, "nextcloud/trusted-domains", "example.org cloud.example.org localhost"},
+		{"unsaved email follows the saved domain", map[string]any{"core/domain": "example.org"}, "nginx-proxy-manager/letsencrypt-email", "admin@example.org"},
+		{"cleared value follows the saved domain", map[string]any{"core/domain": "example.org", "nginx-proxy-manager/letsencrypt-email": ""}, "nginx-proxy-manager/letsencrypt-email", "admin@example.org"},
+		{"saved value is kept", map[string]any{"core/domain": "example.org", "nginx-proxy-manager/letsencrypt-email": "me@mail.example.net"}, "nginx-proxy-manager/letsencrypt-email", "me@mail.example.net"},
+		{"no domain keeps the fallback", nil, "nextcloud/trusted-domains", "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// newDefaultTree assembles the tree like zhi: List and Get on
+			// the plugin, then the saved values on top.
+			tree := newDefaultTree(t, tt.saved)
+			if err := (computeTransform{p: p}).BeforeDisplay(context.Background(), tree); err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := tree.Get(tt.path); v.Val != tt.want {
+				t.Errorf("%s = %q, want %q", tt.path, v.Val, tt.want)
+			}
+		})
+	}
+
+	// save runs the transform's AfterSave on tree and returns the values
+	// zhi would store: all of them, derived ones included.
+	ctx := context.Background()
+	save := func(t *testing.T, tree *config.Tree) map[string]any {
+		t.Helper()
+		if err := (computeTransform{p: p}).AfterSave(ctx, tree); err != nil {
+			t.Fatal(err)
+		}
+		stored := map[string]any{}
+		for _, path := range tree.List() {
+			v, _ := tree.Get(path)
+			stored[path] = v.Val
+		}
+		return stored
+	}
+	saveTests := []struct {
+		name   string
+		edit   string // trusted domains set by the user before saving, "" for none
+		domain func(t *testing.T, stored map[string]any) *config.Tree
+		want   string
+	}{
+		{
+			name: "saved derived value follows a new domain",
+			domain: func(t *testing.T, stored map[string]any) *config.Tree {
+				stored["core/domain"] = "example.net"
+				tree := newDefaultTree(t, stored)
+				if err := (computeTransform{p: p}).BeforeDisplay(ctx, tree); err != nil {
+					t.Fatal(err)
+				}
+				return tree
+			},
+			want: "example.net cloud.example.net localhost",
+		},
+		{
+			name: "zhi set of the domain updates the saved derived value",
+			domain: func(t *testing.T, stored map[string]any) *config.Tree {
+				// zhi set loads the tree without BeforeDisplay.
+				stored["core/domain"] = "example.net"
+				tree := newDefaultTree(t, stored)
+				save(t, tree)
+				return tree
+			},
+			want: "example.net cloud.example.net localhost",
+		},
+		{
+			name: "saved user value keeps its value",
+			edit: "nc.example.org",
+			domain: func(t *testing.T, stored map[string]any) *config.Tree {
+				stored["core/domain"] = "example.net"
+				tree := newDefaultTree(t, stored)
+				if err := (computeTransform{p: p}).BeforeDisplay(ctx, tree); err != nil {
+					t.Fatal(err)
+				}
+				return tree
+			},
+			want: "nc.example.org",
+		},
+	}
+	for _, tt := range saveTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newDefaultTree(t, map[string]any{"core/domain": "example.org"})
+			if err := (computeTransform{p: p}).BeforeDisplay(ctx, tree); err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != "" {
+				v, _ := tree.GetPtr("nextcloud/trusted-domains")
+				v.Val = tt.edit
+			}
+			tree = tt.domain(t, save(t, tree))
+			if v, _ := tree.Get("nextcloud/trusted-domains"); v.Val != tt.want {
+				t.Errorf("nextcloud/trusted-domains = %q, want %q", v.Val, tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedDomainsCoverServedHost(t *testing.T) {
+	for _, state := range []string{`{"nextcloud": true}`, `{"nextcloud": true, "nginx-proxy-manager": true}`} {
+		t.Run(state, func(t *testing.T) {
+			t.Setenv("ZHI_WORKSPACE", writeWorkspace(t, state))
+			p := newHomeserverPlugin()
+			ctx := context.Background()
+			if err := p.Set(ctx, "core/domain", config.Value{Val: "example.org"}); err != nil {
+				t.Fatal(err)
+			}
+			host, _, _ := p.Get(ctx, "nextcloud/overwrite-host")
+			domains, _, _ := p.Get(ctx, "nextcloud/trusted-domains")
+			name, _, _ := strings.Cut(host.Val.(string), ":")
+			if !slices.Contains(strings.Fields(domains.Val.(string)), name) {
+				t.Errorf("trusted-domains %q miss served host %q", domains.Val, host.Val)
+			}
+		})
+	}
+}
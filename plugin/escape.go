@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// escapeShell quotes s for POSIX shells. The result is a single-quoted
+// word in which nothing is expanded; each embedded single quote closes
+// the quoting, adds an escaped quote and reopens it. It matches zhi's
+// shellQuote template function.
+func escapeShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// envBareRe matches values that need no quoting in an env file.
+var envBareRe = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=-]*$`)
+
+// escapeEnvFile renders s as the value of a KEY=value line in a Compose
+// .env file, which can also be sourced by a shell. Plain values are left
+// bare, everything else is single-quoted so nothing is interpolated.
+// Values containing a single quote are double-quoted with \, ", $ and `
+// escaped instead.
+func escapeEnvFile(s string) string {
+	switch {
+	case envBareRe.MatchString(s):
+		return s
+	case !strings.Contains(s, "'"):
+		return "'" + s + "'"
+	default:
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`").Replace(s) + `"`
+	}
+}
+
+// escapedChars are characters with a special meaning in the generated
+// shell scripts or Compose file, and where they matter.
+var escapedChars = []struct {
+	Char    rune
+	Context string
+}{
+	{'\'', "shell single quotes"},
+	{'"', "double quotes"},
+	{'$', "shell and Compose variable expansion"},
+	{'`', "shell command substitution"},
+	{'\\', "escape sequences"},
+}
+
+// validateUnsafeChars reports characters in string values that cannot be
+// written into the generated files, or that only survive because the
+// templates escape them. Control characters such as newlines change the
+// structure of scripts and env files and are Blocking; quotes, $ and
+// backticks are escaped and reported as Info.
+func validateUnsafeChars(d *ValueDef, v config.Value) []config.ValidationResult {
+	if d.Type != "string" {
+		return nil
+	}
+	s, ok := v.Val.(string)
+	if !ok || s == "" {
+		return nil
+	}
+	if !utf8.ValidString(s) {
+		return []config.ValidationResult{{
+			Message:  "Contains invalid UTF-8 and cannot be written into the generated files.",
+			Severity: config.Blocking,
+		}}
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return []config.ValidationResult{{
+				Message:  fmt.Sprintf("Contains the control character %U (%s), which breaks the generated scripts and Compose file. Remove it.", r, controlName(r)),
+				Severity: config.Blocking,
+			}}
+		}
+	}
+	var found []string
+	for _, c := range escapedChars {
+		if strings.ContainsRune(s, c.Char) {
+			found = append(found, fmt.Sprintf("%c (%s)", c.Char, c.Context))
+		}
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	return []config.ValidationResult{{
+		Message:  fmt.Sprintf("Contains %s. The generated files escape these characters; check any scripts of your own that use this value.", strings.Join(found, ", ")),
+		Severity: config.Info,
+	}}
+}
+
+// controlName names common control characters for messages.
+func controlName(r rune) string {
+	switch r {
+	case '\n':
+		return "newline"
+	case '\r':
+		return "carriage return"
+	case '\t':
+		return "tab"
+	case 0:
+		return "NUL"
+	}
+	return "control character"
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+var escapeInputs = []string{
+	"",
+	"plain",
+	"with space",
+	"it's",
+	`say "hi"`,
+	"$HOME and ${USER}",
+	"`id`",
+	`back\slash`,
+	"mixed'\"$`\\ all",
+	"ünïcödé",
+}
+
+func TestEscapeShell(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	for _, in := range escapeInputs {
+		t.Run(in, func(t *testing.T) {
+			out, err := exec.Command(sh, "-c", "printf %s "+escapeShell(in)).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != in {
+				t.Errorf("shell read %q, want %q", out, in)
+			}
+		})
+	}
+}
+
+func TestEscapeEnvFile(t *testing.T) {
+	if got := escapeEnvFile("plain-value_1.2"); got != "plain-value_1.2" {
+		t.Errorf("plain value quoted: %s", got)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+	for _, in := range escapeInputs {
+		t.Run(in, func(t *testing.T) {
+			out, err := exec.Command(sh, "-c", "V="+escapeEnvFile(in)+`; printf %s "$V"`).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != in {
+				t.Errorf("env file read %q, want %q", out, in)
+			}
+		})
+	}
+}
+
+func TestValidateUnsafeChars(t *testing.T) {
+	tests := []struct {
+		name string
+		def  ValueDef
+		val  any
+		want []config.Severity
+	}{
+		{"plain", ValueDef{Type: "string"}, "Xk9vQ2mL7pR4tW8z", nil},
+		{"empty", ValueDef{Type: "string"}, "", nil},
+		{"newline", ValueDef{Type: "string"}, "line1\nline2", []config.Severity{config.Blocking}},
+		{"tab", ValueDef{Type: "string"}, "a\tb", []config.Severity{config.Blocking}},
+		{"invalid utf-8", ValueDef{Type: "string"}, "a\xffb", []config.Severity{config.Blocking}},
+		{"single quote", ValueDef{Type: "string"}, "it's", []config.Severity{config.Info}},
+		{"dollar and backtick", ValueDef{Type: "string"}, "$(x)`y`", []config.Severity{config.Info}},
+		{"not a string type", ValueDef{Type: "int"}, 8080, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := validateUnsafeChars(&tt.def, config.Value{Val: tt.val})
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d: %v", len(results), len(tt.want), results)
+			}
+			for i, r := range results {
+				if r.Severity != tt.want[i] {
+					t.Errorf("result %d severity = %v, want %v", i, r.Severity, tt.want[i])
+				}
+			}
+		})
+	}
+}
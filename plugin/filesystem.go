@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// fsStats describes the file system containing a path.
+type fsStats struct {
+	Device    uint64 // device ID, equal for paths on the same file system
+	Avail     uint64 // bytes available to unprivileged users
+	Size      uint64 // total bytes
+	Files     uint64 // total inodes, 0 if the file system has no fixed limit
+	FreeFiles uint64
+	Tmpfs     bool
+	Supported bool // false where statFS is not implemented
+}
+
+// Thresholds of the file system checks. Below the low marks a path gets a
+// Warning, below the critical ones a Blocking result if the stack writes
+// to it.
+const (
+	lowSpace       = 5 << 30
+	criticalSpace  = 512 << 20
+	lowInodes      = 0.05
+	criticalInodes = 1000
+)
+
+// fsPaths are the host directories whose file systems are checked, with
+// what fills them. The stack writes to the Written ones, so running out of
+// space or inodes there blocks; Plex only reads the media libraries.
+var fsPaths = map[string]struct {
+	What    string
+	Written bool
+}{
+	"core/data-root":    {"the service data", true},
+	"core/backup-dir":   {"the backups", true},
+	"plex/media-movies": {"the movie library", false},
+	"plex/media-tv":     {"the TV library", false},
+	"plex/media-music":  {"the music library", false},
+}
+
+// stat returns the file system statistics of the absolute host path name.
+func (h *hostInspector) stat(name string) (fsStats, error) {
+	if h.statfs != nil {
+		return h.statfs(name)
+	}
+	return statFS(h.path(name))
+}
+
+// checkFilesystems reports host directories on a file system that is
+// nearly full or out of inodes, on tmpfs, which loses its content on
+// reboot, or on the root file system, which the host needs to keep
+// working. Directories that do not exist are reported by checkHostPaths.
+func checkFilesystems(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult {
+	fp, ok := fsPaths[path]
+	if !ok {
+		return nil
+	}
+	what, critical := fp.What, config.Warning
+	if fp.Written {
+		critical = config.Blocking
+	}
+	dir := treeString(tree, path)
+	if !filepath.IsAbs(dir) {
+		return nil
+	}
+	if _, err := os.Stat(h.path(dir)); err != nil {
+		return nil
+	}
+	st, err := h.stat(dir)
+	if err != nil {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Cannot inspect the file system of %s: %v", dir, err),
+			Severity: config.Info,
+		}}
+	}
+	if !st.Supported {
+		return nil
+	}
+
+	var results []config.ValidationResult
+	switch {
+	case st.Avail < criticalSpace:
+		results = append(results, config.ValidationResult{
+			Message:  fmt.Sprintf("Only %s are free on the file system of %s, too little for %s. Free up space or choose another disk.", humanBytes(st.Avail), dir, what),
+			Severity: critical,
+		})
+	case st.Avail < lowSpace:
+		results = append(results, config.ValidationResult{
+			Message:  fmt.Sprintf("Only %s of %s are free on the file system of %s, which holds %s.", humanBytes(st.Avail), humanBytes(st.Size), dir, what),
+			Severity: config.Warning,
+		})
+	}
+	if st.Files > 0 {
+		switch {
+		case st.FreeFiles < criticalInodes:
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("The file system of %s has only %d free inodes, so no new files can be created soon.", dir, st.FreeFiles),
+				Severity: critical,
+			})
+		case float64(st.FreeFiles) < lowInodes*float64(st.Files):
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("The file system of %s has %d of %d inodes free. Many small files, e.g. Nextcloud previews, can use them up before the space runs out.", dir, st.FreeFiles, st.Files),
+				Severity: config.Warning,
+			})
+		}
+	}
+	if st.Tmpfs {
+		results = append(results, config.ValidationResult{
+			Message:  fmt.Sprintf("%s is on tmpfs, so %s would be lost on reboot. Choose a directory on a disk.", dir, what),
+			Severity: config.Warning,
+		})
+	} else if root, err := h.stat("/"); err == nil && root.Device == st.Device {
+		results = append(results, config.ValidationResult{
+			Message:  fmt.Sprintf("%s is on the root file system. Filling it with %s can stop the host itself from working; consider a separate disk.", dir, what),
+			Severity: config.Warning,
+		})
+	}
+	return results
+}
+
+// humanBytes renders n in binary units with one decimal, e.g. 1.5 GiB.
+func humanBytes(n uint64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
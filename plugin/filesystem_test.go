@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestCheckFilesystems(t *testing.T) {
+	const gib = 1 << 30
+	roomy := fsStats{Device: 2, Avail: 100 * gib, Size: 200 * gib, Files: 1e6, FreeFiles: 5e5, Supported: true}
+	with := func(change func(*fsStats)) fsStats {
+		st := roomy
+		change(&st)
+		return st
+	}
+	tests := []struct {
+		name  string
+		path  string
+		stats fsStats
+		want  []config.Severity
+		msg   string
+	}{
+		{"roomy separate disk", "core/data-root", roomy, nil, ""},
+		{"unsupported", "core/data-root", fsStats{}, nil, ""},
+		{"low space", "core/data-root", with(func(s *fsStats) { s.Avail = 2 * gib }), []config.Severity{config.Warning}, "Only 2.0 GiB of 200.0 GiB are free"},
+		{"full", "core/backup-dir", with(func(s *fsStats) { s.Avail = 100 << 20 }), []config.Severity{config.Blocking}, "too little for the backups"},
+		{"full media library", "plex/media-movies", with(func(s *fsStats) { s.Avail = 0 }), []config.Severity{config.Warning}, "too little for the movie library"},
+		{"few inodes", "core/data-root", with(func(s *fsStats) { s.FreeFiles = 10000 }), []config.Severity{config.Warning}, "10000 of 1000000 inodes free"},
+		{"no inodes", "core/data-root", with(func(s *fsStats) { s.FreeFiles = 10 }), []config.Severity{config.Blocking}, "only 10 free inodes"},
+		{"no inode limit", "core/data-root", with(func(s *fsStats) { s.Files, s.FreeFiles = 0, 0 }), nil, ""},
+		{"tmpfs", "core/data-root", with(func(s *fsStats) { s.Tmpfs = true }), []config.Severity{config.Warning}, "is on tmpfs"},
+		{"root file system", "core/backup-dir", with(func(s *fsStats) { s.Device = 1 }), []config.Severity{config.Warning}, "is on the root file system"},
+		{"unrelated path", "core/timezone", roomy, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := fakeHost(t, map[string]string{"/srv/dir/": ""})
+			h.statfs = func(name string) (fsStats, error) {
+				if name == "/" {
+					return fsStats{Device: 1, Supported: true}, nil
+				}
+				return tt.stats, nil
+			}
+			tree := config.NewTree()
+			tree.Set(tt.path, &config.Value{Val: "/srv/dir"})
+			results := checkFilesystems(h, tt.path, tree)
+			if len(results) != len(tt.want) {
+				t.Fatalf("results = %v, want %v", results, tt.want)
+			}
+			for i, sev := range tt.want {
+				if results[i].Severity != sev {
+					t.Errorf("severity = %v, want %v", results[i].Severity, sev)
+				}
+			}
+			if tt.msg != "" && !strings.Contains(results[0].Message, tt.msg) {
+				t.Errorf("message %q does not contain %q", results[0].Message, tt.msg)
+			}
+		})
+	}
+
+	t.Run("missing directory", func(t *testing.T) {
+		h := fakeHost(t, nil)
+		h.statfs = func(string) (fsStats, error) { return fsStats{}, errors.New("not reached") }
+		tree := config.NewTree()
+		tree.Set("core/data-root", &config.Value{Val: "/srv/missing"})
+		if results := checkFilesystems(h, "core/data-root", tree); len(results) > 0 {
+			t.Errorf("unexpected results %v", results)
+		}
+	})
+}
+
+func TestStatFS(t *testing.T) {
+	st, err := statFS(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Supported != (runtime.GOOS == "linux") {
+		t.Errorf("Supported = %v on %s", st.Supported, runtime.GOOS)
+	}
+	if st.Supported && (st.Size == 0 || st.Avail > st.Size) {
+		t.Errorf("implausible statistics %+v", st)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KiB"},
+		{1536 << 20, "1.5 GiB"},
+		{5 << 40, "5.0 TiB"},
+	}
+	for _, tt := range tests {
+		if got := humanBytes(tt.n); got != tt.want {
+			t.Errorf("humanBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
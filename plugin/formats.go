@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// formatDef describes a named string format that a ValueDef can declare.
+type formatDef struct {
+	// Check reports whether s is a valid value of the format.
+	Check func(s string) bool
+	// Pattern is an optional regular expression exported as ui.pattern so
+	// UIs can validate input before it reaches the plugin.
+	Pattern string
+	// Hint describes the expected input in validation messages.
+	Hint string
+}
+
+var (
+	hostnameRe = regexp.MustCompile(`^(?i)[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?)*$`)
+	domainRe   = regexp.MustCompile(`^(?i)(?:[a-z0-9](?:[a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z][a-z0-9-]*[a-z0-9]$`)
+)
+
+// formats maps format names to their definitions.
+var formats = map[string]formatDef{
+	"domain": {
+		Check:   func(s string) bool { return len(s) <= 253 && domainRe.MatchString(s) },
+		Pattern: domainRe.String(),
+		Hint:    "a domain name such as home.example.com",
+	},
+	"hostname": {
+		Check:   func(s string) bool { return len(s) <= 253 && hostnameRe.MatchString(s) },
+		Pattern: hostnameRe.String(),
+		Hint:    "a hostname such as smtp.example.com",
+	},
+	"email": {
+		Check: func(s string) bool {
+			addr, err := mail.ParseAddress(s)
+			return err == nil && addr.Address == s && strings.Contains(s[strings.LastIndex(s, "@"):], ".")
+		},
+		Hint: "an email address such as admin@example.com",
+	},
+	"url": {
+		Check: func(s string) bool {
+			u, err := url.Parse(s)
+			return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+		},
+		Hint: "an http:// or https:// URL",
+	},
+	"ipv4": {
+		Check: func(s string) bool {
+			a, err := netip.ParseAddr(s)
+			return err == nil && a.Is4()
+		},
+		Hint: "an IPv4 address such as 192.168.1.10",
+	},
+	"ipv6": {
+		Check: func(s string) bool {
+			a, err := netip.ParseAddr(s)
+			return err == nil && a.Is6() && !a.Is4In6()
+		},
+		Hint: "an IPv6 address such as 2606:4700:4700::1111",
+	},
+	"dns-server": {
+		Check: checkDNSServer,
+		Hint:  "an IP address with optional #port, e.g. 1.1.1.1 or 9.9.9.9#53",
+	},
+	"tz": {
+		Check: func(s string) bool {
+			_, err := time.LoadLocation(s)
+			return err == nil && s != "Local"
+		},
+		Hint: "a TZ database name such as Europe/Berlin",
+	},
+	"size": {
+		Check: func(s string) bool {
+			_, err := parseSize(s)
+			return err == nil
+		},
+		Pattern: sizeExprRe.String(),
+		Hint:    "a size such as 256M, 1G or 128mb",
+	},
+}
+
+// checkDNSServer accepts an IPv4 or IPv6 address optionally followed by
+// "#port", the upstream syntax used by PiHole.
+func checkDNSServer(s string) bool {
+	addr, port, hasPort := strings.Cut(s, "#")
+	if hasPort {
+		n, ok := toInt(port)
+		if !ok || n < 1 || n > 65535 {
+			return false
+		}
+	}
+	_, err := netip.ParseAddr(addr)
+	return err == nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestFormats(t *testing.T) {
+	tests := []struct {
+		format string
+		val    string
+		valid  bool
+	}{
+		{"domain", "home.example.com", true},
+		{"domain", "example.com", true},
+		{"domain", "localhost", false},
+		{"domain", "home..example.com", false},
+		{"domain", "https://example.com", false},
+		{"hostname", "smtp.example.com", true},
+		{"hostname", "mailserver", true},
+		{"hostname", "smtp example.com", false},
+		{"email", "admin@example.com", true},
+		{"email", "Admin <admin@example.com>", false},
+		{"email", "admin@localhost", false},
+		{"email", "admin.example.com", false},
+		{"url", "https://example.com/list.txt", true},
+		{"url", "ftp://example.com/list.txt", false},
+		{"url", "example.com/list.txt", false},
+		{"ipv4", "192.168.1.10", true},
+		{"ipv4", "::1", false},
+		{"ipv6", "2606:4700:4700::1111", true},
+		{"ipv6", "1.1.1.1", false},
+		{"dns-server", "1.1.1.1", true},
+		{"dns-server", "9.9.9.9#53", true},
+		{"dns-server", "2620:fe::fe", true},
+		{"dns-server", "1.1.1.1#99999", false},
+		{"dns-server", "dns.google", false},
+		{"tz", "Europe/Berlin", true},
+		{"tz", "UTC", true},
+		{"tz", "Europe/Berlinn", false},
+		{"size", "256M", true},
+		{"size", "128mb", true},
+		{"size", "16G", true},
+		{"size", "1.5 GiB", true},
+		{"size", "lots", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.val, func(t *testing.T) {
+			f, ok := formats[tt.format]
+			if !ok {
+				t.Fatalf("unknown format %q", tt.format)
+			}
+			if got := f.Check(tt.val); got != tt.valid {
+				t.Errorf("Check(%q) = %v, want %v", tt.val, got, tt.valid)
+			}
+		})
+	}
+}
+
+func TestAllDefsHaveKnownFormats(t *testing.T) {
+	for _, d := range valueDefs {
+		if d.Format != "" {
+			if _, ok := formats[d.Format]; !ok {
+				t.Errorf("path %q: unknown format %q", d.Path, d.Format)
+			}
+		}
+	}
+}
+
+func TestAllDefaultsPassFormatValidation(t *testing.T) {
+	for _, d := range valueDefs {
+		if results := validateFormat(&d, config.Value{Val: d.defaultValue(config.NewTree())}); len(results) > 0 {
+			t.Errorf("path %q: default %v fails format validation: %v", d.Path, d.Default, results)
+		}
+	}
+}
+
+func TestValidateFormatHidesPasswords(t *testing.T) {
+	d := newHomeserverPlugin().defs["plex/claim-token"]
+	results := validateFormat(d, config.Value{Val: "hunter2-secret"})
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %v", len(results), results)
+	}
+	if strings.Contains(results[0].Message, "hunter2-secret") {
+		t.Errorf("message %q echoes the password", results[0].Message)
+	}
+}
+
+func TestValidateFormatLists(t *testing.T) {
+	d := &ValueDef{Path: "test/upstreams", Type: "string", Format: "dns-server", Separator: ";"}
+	results := validateFormat(d, config.Value{Val: "1.1.1.1; 8.8.8.8;bogus;"})
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %v", len(results), results)
+	}
+	if results[0].Severity != config.Blocking {
+		t.Errorf("severity = %v, want Blocking", results[0].Severity)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// updateGolden rewrites the golden files from the current templates:
+//
+//	go test -run TestGolden -update
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// componentSets returns every set of optional components of st that is
+// closed under dependencies, i.e. every state "zhi component enable" can
+// produce, named by its sorted components joined with "+".
+func componentSets(t *testing.T, st *componentState) map[string]*componentState {
+	t.Helper()
+	var optional []string
+	for _, d := range st.defs {
+		if !d.Mandatory {
+			optional = append(optional, d.Name)
+		}
+	}
+	slices.Sort(optional)
+	sets := map[string]*componentState{}
+	for mask := range 1 << len(optional) {
+		var names []string
+		for i, name := range optional {
+			if mask&(1<<i) != 0 {
+				names = append(names, name)
+			}
+		}
+		only, err := st.withOnly(names)
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed := true
+		for _, name := range optional {
+			if only.Enabled(name) != slices.Contains(names, name) {
+				closed = false
+			}
+		}
+		if !closed {
+			continue
+		}
+		key := strings.Join(names, "+")
+		if key == "" {
+			key = "none"
+		}
+		sets[key] = only
+	}
+	return sets
+}
+
+// renderGolden renders the workspace templates with the fixture values and
+// the components of st, in the format of the render command's stdout.
+func renderGolden(t *testing.T, tmpls []exportTemplate, values map[string]any, st *componentState) (string, map[string]string) {
+	t.Helper()
+	p := newHomeserverPlugin()
+	p.components = st
+	if errs := p.applyValues(values); len(errs) > 0 {
+		t.Fatalf("fixture values rejected: %v", errs)
+	}
+	files, err := renderTemplates(tmpls, p.currentTree(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	byName := map[string]string{}
+	for _, f := range files {
+		buf.WriteString("# ==> " + filepath.Base(f.Output) + " <==\n")
+		buf.WriteString(f.Content)
+		byName[f.Name] = f.Content
+	}
+	return buf.String(), byName
+}
+
+func TestGolden(t *testing.T) {
+	st, err := loadComponentState(testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpls, err := loadExportTemplates(testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := readValuesFile(filepath.Join("testdata", "values.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sets := componentSets(t, st)
+	for name, set := range sets {
+		t.Run(name, func(t *testing.T) {
+			got, files := renderGolden(t, tmpls, values, set)
+			checkComposeInvariants(t, files[composeTemplate])
+
+			golden := filepath.Join("testdata", "golden", name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -run TestGolden -update to create it)", err)
+			}
+			if got != string(want) {
+				t.Errorf("output differs from %s (run go test -run TestGolden -update to accept it):\n%s", golden, lineDiff(string(want), got))
+			}
+		})
+	}
+
+	// Golden files of component sets that no longer exist would never be
+	// compared again.
+	stale, err := filepath.Glob(filepath.Join("testdata", "golden", "*.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range stale {
+		if _, ok := sets[strings.TrimSuffix(filepath.Base(file), ".golden")]; ok {
+			continue
+		}
+		if *updateGolden {
+			if err := os.Remove(file); err != nil {
+				t.Error(err)
+			}
+			continue
+		}
+		t.Errorf("%s matches no component set", file)
+	}
+}
+
+// checkComposeInvariants checks what every rendered Compose file must
+// satisfy, independently of checkCompose: it is valid YAML, every image
+// has a tag and every named volume a service mounts is declared.
+func checkComposeInvariants(t *testing.T, content string) {
+	t.Helper()
+	var compose struct {
+		Volumes  map[string]any `yaml:"volumes"`
+		Services map[string]struct {
+			Image   string   `yaml:"image"`
+			Volumes []string `yaml:"volumes"`
+		} `yaml:"services"`
+	}
+	if err := yaml.Unmarshal([]byte(content), &compose); err != nil {
+		t.Fatalf("docker-compose.yml is not valid YAML: %v", err)
+	}
+	for name, svc := range compose.Services {
+		if repo, tag, ok := strings.Cut(svc.Image, ":"); !ok || repo == "" || tag == "" {
+			t.Errorf("service %s has image %q without a tag", name, svc.Image)
+		}
+		for _, v := range svc.Volumes {
+			source, _, _ := strings.Cut(v, ":")
+			if strings.HasPrefix(source, "/") {
+				continue
+			}
+			if _, ok := compose.Volumes[source]; !ok {
+				t.Errorf("service %s mounts volume %q, which is not declared", name, source)
+			}
+		}
+	}
+	for _, problem := range checkCompose([]byte(content)) {
+		t.Errorf("docker-compose.yml: %s", problem)
+	}
+}
+
+// lineDiff returns the lines of want and got from the first one that
+// differs, a few lines each.
+func lineDiff(want, got string) string {
+	w, g := strings.Split(want, "\n"), strings.Split(got, "\n")
+	i := 0
+	for i < len(w) && i < len(g) && w[i] == g[i] {
+		i++
+	}
+	const context = 5
+	var sb strings.Builder
+	for _, side := range []struct {
+		prefix string
+		lines  []string
+	}{{"-", w}, {"+", g}} {
+		for j := i; j < len(side.lines) && j < i+context; j++ {
+			sb.WriteString(side.prefix + " " + side.lines[j] + "\n")
+		}
+	}
+	return "first difference at line " + strconv.Itoa(i+1) + ":\n" + sb.String()
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"github.com/MrWong99/zhi/pkg/zhiplugin/pluginopts"
+)
+
+// hostChecksEnabled reports whether validation inspects the host the
+// plugin runs on, set through the "host-checks" plugin option or the
+// ZHI_HOST_CHECKS environment variable. It is off by default because zhi
+// may run on another machine than the one the stack is deployed to.
+func hostChecksEnabled() bool {
+	if pluginopts.Bool(pluginopts.Options(), "host-checks", false) {
+		return true
+	}
+	on, _ := strconv.ParseBool(os.Getenv("ZHI_HOST_CHECKS"))
+	return on
+}
+
+// hostInspector reads the state of the host from its file system. root is
+// where the host's file system is mounted: "/" except in tests.
+type hostInspector struct {
+	root string
+
+	// statfs replaces statFS in tests.
+	statfs func(name string) (fsStats, error)
+	// interfaceAddrs replaces net.InterfaceAddrs in tests.
+	interfaceAddrs func() ([]net.Addr, error)
+}
+
+// localHost inspects the host the plugin runs on.
+var localHost = &hostInspector{root: "/"}
+
+// path returns the location of the absolute host path name below root.
+func (h *hostInspector) path(name string) string {
+	return filepath.Join(h.root, name)
+}
+
+// socket is a bound socket from the kernel's /proc/net tables.
+type socket struct {
+	Proto string // tcp or udp
+	Addr  netip.Addr
+	Port  int
+	UID   string // user owning the socket
+	Inode string
+}
+
+// procNetTables maps the /proc/net tables to the protocol of their
+// sockets.
+var procNetTables = map[string]string{
+	"tcp":  "tcp",
+	"tcp6": "tcp",
+	"udp":  "udp",
+	"udp6": "udp",
+}
+
+// tcpListen is the state of listening TCP sockets in /proc/net/tcp.
+const tcpListen = "0A"
+
+// listeners returns the listening TCP sockets and the bound UDP sockets of
+// the host. Tables that cannot be read are skipped; the error is only
+// returned if none could be read.
+func (h *hostInspector) listeners() ([]socket, error) {
+	var out []socket
+	var errs []error
+	for _, table := range slices.Sorted(maps.Keys(procNetTables)) {
+		proto := procNetTables[table]
+		f, err := os.Open(h.path("/proc/net/" + table))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		sc := bufio.NewScanner(f)
+		sc.Scan() // header
+		for sc.Scan() {
+			fields := strings.Fields(sc.Text())
+			if len(fields) < 10 || proto == "tcp" && fields[3] != tcpListen {
+				continue
+			}
+			addr, port, err := parseProcAddr(fields[1])
+			if err != nil {
+				continue
+			}
+			out = append(out, socket{Proto: proto, Addr: addr, Port: port, UID: fields[7], Inode: fields[9]})
+		}
+		f.Close()
+	}
+	if len(errs) == len(procNetTables) {
+		return nil, errors.Join(errs...)
+	}
+	return out, nil
+}
+
+// parseProcAddr parses an address of the /proc/net tables such as
+// 3500007F:0035 (127.0.0.53:53). The address is hex in host byte order,
+// in 32-bit words for IPv6.
+func parseProcAddr(s string) (netip.Addr, int, error) {
+	ipHex, portHex, ok := strings.Cut(s, ":")
+	if !ok {
+		return netip.Addr{}, 0, fmt.Errorf("invalid address %q", s)
+	}
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.Addr{}, 0, err
+	}
+	raw, err := hex.DecodeString(ipHex)
+	if err != nil || len(raw) != 4 && len(raw) != 16 {
+		return netip.Addr{}, 0, fmt.Errorf("invalid address %q", s)
+	}
+	ip := make([]byte, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		binary.BigEndian.PutUint32(ip[i:], binary.LittleEndian.Uint32(raw[i:]))
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return addr.Unmap(), int(port), nil
+}
+
+// socketOwner returns the command name of the process holding the socket
+// with inode, or "" if it cannot be found, e.g. without the permission to
+// read other users' file descriptors.
+func (h *hostInspector) socketOwner(inode string) string {
+	target := "socket:[" + inode + "]"
+	fds, _ := filepath.Glob(h.path("/proc/[0-9]*/fd/*"))
+	for _, fd := range fds {
+		if link, err := os.Readlink(fd); err == nil && link == target {
+			comm, err := os.ReadFile(filepath.Join(filepath.Dir(filepath.Dir(fd)), "comm"))
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSpace(string(comm))
+		}
+	}
+	return ""
+}
+
+// resolvedDropInDirs are the drop-in directories of resolved.conf, from
+// lowest to highest precedence.
+var resolvedDropInDirs = []string{
+	"/usr/lib/systemd/resolved.conf.d",
+	"/run/systemd/resolved.conf.d",
+	"/etc/systemd/resolved.conf.d",
+}
+
+// resolvedStubAddrs are the addresses the systemd-resolved stub listeners
+// bind to.
+var resolvedStubAddrs = map[netip.Addr]bool{
+	netip.MustParseAddr("127.0.0.53"): true,
+	netip.MustParseAddr("127.0.0.54"): true,
+}
+
+// overlapsResolvedStub reports whether a socket on addr collides with the
+// systemd-resolved stub listeners. A single LAN address does not.
+func overlapsResolvedStub(addr string) bool {
+	for a := range resolvedStubAddrs {
+		if addrsOverlap(addr, a.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvedStubListener reports whether systemd-resolved runs with its DNS
+// stub listener on port 53, and the file that configured it last, if any.
+// Like systemd, it reads /etc/systemd/resolved.conf and then the drop-ins
+// in file name order, a drop-in in /etc replacing one of the same name in
+// /run or /usr/lib. The stub listener is on unless DNSStubListener is
+// set to no.
+func (h *hostInspector) resolvedStubListener() (bool, string) {
+	if fi, err := os.Stat(h.path("/run/systemd/resolve")); err != nil || !fi.IsDir() {
+		return false, "" // systemd-resolved is not running
+	}
+	dropIns := map[string]string{}
+	for _, dir := range resolvedDropInDirs {
+		files, _ := filepath.Glob(h.path(dir) + "/*.conf")
+		for _, f := range files {
+			dropIns[filepath.Base(f)] = filepath.Join(dir, filepath.Base(f))
+		}
+	}
+	files := []string{"/etc/systemd/resolved.conf"}
+	for _, name := range slices.Sorted(maps.Keys(dropIns)) {
+		files = append(files, dropIns[name])
+	}
+	stub, source := true, ""
+	for _, f := range files {
+		if v, ok := h.systemdSetting(f, "Resolve", "DNSStubListener"); ok {
+			stub, source = !slices.Contains([]string{"no", "false", "off", "0"}, strings.ToLower(v)), f
+		}
+	}
+	return stub, source
+}
+
+// systemdSetting returns the last value of key in section of the systemd
+// configuration file name.
+func (h *hostInspector) systemdSetting(name, section, key string) (string, bool) {
+	data, err := os.ReadFile(h.path(name))
+	if err != nil {
+		return "", false
+	}
+	var value string
+	found, inSection := false, false
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[':
+			inSection = line == "["+section+"]"
+		case inSection:
+			if k, v, ok := strings.Cut(line, "="); ok && strings.TrimSpace(k) == key {
+				value, found = strings.TrimSpace(v), true
+			}
+		}
+	}
+	return value, found
+}
+
+// hostCheckFunc inspects the host for the value at path. Like
+// treeValidatorFunc, each one decides by itself whether path is relevant.
+type hostCheckFunc func(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult
+
+// hostChecks run for every validated path when host checks are enabled.
+var hostChecks = []hostCheckFunc{
+	checkDNSPort,
+	checkRenderDevice,
+	checkHostPaths,
+	checkFilesystems,
+	checkBindAddress,
+}
+
+// checkDNSPort blocks a DNS port that PiHole cannot bind on the host, see
+// dnsPortConflicts. Port 53 is the only port LAN clients use for DNS, so
+// it passes whenever it is free on pihole/dns-bind-address.
+func checkDNSPort(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "pihole/dns-port" {
+		return nil
+	}
+	v, _ := tree.Get(path)
+	port, ok := toInt(v.Val)
+	if !ok {
+		return nil
+	}
+	return h.dnsPortConflicts(port, bindAddress(tree, "pihole/dns-bind-address"))
+}
+
+// dnsPortConflicts reports what keeps PiHole from binding port on addr
+// ("" for all interfaces): the systemd-resolved stub listener for port 53,
+// or any other listener on the port and an overlapping address. A port
+// held by docker-proxy most likely belongs to the running PiHole container
+// and is not a conflict. Without root, the owner of another user's socket
+// cannot be seen; such a listener is only a Warning, or Info if it belongs
+// to root like docker-proxy. Without /proc/net, e.g. on macOS, no conflict
+// can be found.
+func (h *hostInspector) dnsPortConflicts(port int, addr string) []config.ValidationResult {
+	var results []config.ValidationResult
+	stub := false
+	if port == 53 && overlapsResolvedStub(addr) {
+		var source string
+		if stub, source = h.resolvedStubListener(); stub {
+			if source == "" {
+				source = "its default configuration"
+			}
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("systemd-resolved listens on port 53 (DNSStubListener, set by %s). Disable it: sudo mkdir -p /etc/systemd/resolved.conf.d && printf '[Resolve]\\nDNSStubListener=no\\n' | sudo tee /etc/systemd/resolved.conf.d/no-stub.conf && sudo systemctl restart systemd-resolved", source),
+				Severity: config.Blocking,
+			})
+		}
+	}
+	socks, err := h.listeners()
+	if err != nil {
+		return results
+	}
+	reported := map[string]bool{}
+	for _, s := range socks {
+		if s.Port != port || reported[s.Proto] || stub && resolvedStubAddrs[s.Addr] || !addrsOverlap(addr, s.Addr.String()) {
+			continue
+		}
+		var r config.ValidationResult
+		switch owner := h.socketOwner(s.Inode); {
+		case owner == "docker-proxy":
+			continue
+		case owner != "":
+			r = config.ValidationResult{
+				Message:  fmt.Sprintf("Port %d/%s is already in use on %s by %s. Stop it or choose a different port.", port, s.Proto, s.Addr, owner),
+				Severity: config.Blocking,
+			}
+		case s.UID == "0":
+			r = config.ValidationResult{
+				Message:  fmt.Sprintf("Port %d/%s is in use on %s by a root process that cannot be inspected without root, most likely docker-proxy of the running PiHole container. Run zhi-config-homeserver doctor as root to be sure.", port, s.Proto, s.Addr),
+				Severity: config.Info,
+			}
+		default:
+			r = config.ValidationResult{
+				Message:  fmt.Sprintf("Port %d/%s is in use on %s by a process of user %s that cannot be inspected. Stop it or choose a different port.", port, s.Proto, s.Addr, s.UID),
+				Severity: config.Warning,
+			}
+		}
+		reported[s.Proto] = true
+		results = append(results, r)
+	}
+	return results
+}
+
+// checkRenderDevice reports a missing /dev/dri when Plex hardware
+// transcoding is on, which keeps the Plex container from starting.
+func checkRenderDevice(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "plex/hardware-transcoding" {
+		return nil
+	}
+	v, _ := tree.Get(path)
+	if on, _ := v.Val.(bool); !on {
+		return nil
+	}
+	if _, err := os.Stat(h.path("/dev/dri")); err != nil {
+		return []config.ValidationResult{{
+			Message:  "/dev/dri does not exist on this host, so the Plex container cannot start. Install the GPU drivers or turn hardware transcoding off.",
+			Severity: config.Blocking,
+		}}
+	}
+	return nil
+}
+
+// hostPaths are the host directories the stack uses, with the result for
+// a directory that does not exist yet. Docker creates missing bind-mount
+// sources owned by root; backup.sh creates its directory itself.
+var hostPaths = map[string]config.ValidationResult{
+	"core/data-root":    {Message: "%[1]s does not exist on this host. Docker would create it owned by root; create it first: sudo mkdir -p %[2]s", Severity: config.Warning},
+	"core/backup-dir":   {Message: "%[1]s does not exist yet. backup.sh creates it on its first run.", Severity: config.Info},
+	"plex/media-movies": {Message: "%[1]s does not exist on this host, so Plex would see an empty library. Create it or point it at your media: sudo mkdir -p %[2]s", Severity: config.Warning},
+	"plex/media-tv":     {Message: "%[1]s does not exist on this host, so Plex would see an empty library. Create it or point it at your media: sudo mkdir -p %[2]s", Severity: config.Warning},
+	"plex/media-music":  {Message: "%[1]s does not exist on this host, so Plex would see an empty library. Create it or point it at your media: sudo mkdir -p %[2]s", Severity: config.Warning},
+}
+
+// checkBindAddress reports a bind address that no interface of the host
+// has. Docker then fails to start the container with "cannot assign
+// requested address".
+func checkBindAddress(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult {
+	if path != "core/bind-address" && !slices.ContainsFunc(hostPortDefs, func(d hostPortDef) bool { return d.BindPath == path }) {
+		return nil
+	}
+	addr, err := netip.ParseAddr(treeString(tree, path))
+	if err != nil || addr.IsUnspecified() || addr.IsLoopback() {
+		return nil
+	}
+	interfaceAddrs := net.InterfaceAddrs
+	if h.interfaceAddrs != nil {
+		interfaceAddrs = h.interfaceAddrs
+	}
+	ifaddrs, err := interfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	for _, a := range ifaddrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil && prefix.Addr().Unmap() == addr.Unmap() {
+			return nil
+		}
+	}
+	return []config.ValidationResult{{
+		Message:  fmt.Sprintf("No network interface of this host has the address %s, so Docker cannot publish ports on it. Use one of the addresses shown by \"ip -brief address\".", addr),
+		Severity: config.Blocking,
+	}}
+}
+
+// checkHostPaths reports host directories that do not exist or cannot be
+// written to by the user running the check.
+func checkHostPaths(h *hostInspector, path string, tree config.TreeReader) []config.ValidationResult {
+	missing, ok := hostPaths[path]
+	if !ok {
+		return nil
+	}
+	dir := treeString(tree, path)
+	if !filepath.IsAbs(dir) {
+		return nil // empty or reported by the path's validator
+	}
+	fi, err := os.Stat(h.path(dir))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		missing.Message = fmt.Sprintf(missing.Message, dir, escapeShell(dir))
+		return []config.ValidationResult{missing}
+	case err != nil:
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Cannot inspect %s: %v", dir, err),
+			Severity: config.Warning,
+		}}
+	case !fi.IsDir():
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("%s is not a directory.", dir),
+			Severity: config.Blocking,
+		}}
+	}
+	f, err := os.CreateTemp(h.path(dir), ".zhi-write-test-*")
+	if err != nil {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("%s is not writable by the current user: %v", dir, err),
+			Severity: config.Warning,
+		}}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
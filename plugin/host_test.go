@@ -0,0 +1,410 @@
+package main
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+const procNetHeader = "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+// fakeHost creates a host file system below a temporary root from the
+// given files. Values starting with "->" become symlinks, names ending in
+// "/" directories.
+func fakeHost(t *testing.T, files map[string]string) *hostInspector {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(p, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		var err error
+		if target, ok := strings.CutPrefix(content, "->"); ok {
+			err = os.Symlink(target, p)
+		} else {
+			err = os.WriteFile(p, []byte(content), 0o644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &hostInspector{root: root}
+}
+
+func TestParseProcAddr(t *testing.T) {
+	tests := []struct {
+		in   string
+		addr string
+		port int
+	}{
+		{"3500007F:0035", "127.0.0.53", 53},
+		{"00000000:1F90", "0.0.0.0", 8080},
+		{"00000000000000000000000000000000:0035", "::", 53},
+		{"00000000000000000000000001000000:0277", "::1", 631},
+		{"0000000000000000FFFF00000100007F:0035", "127.0.0.1", 53},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			addr, port, err := parseProcAddr(tt.in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if addr != netip.MustParseAddr(tt.addr) || port != tt.port {
+				t.Errorf("got %s:%d, want %s:%d", addr, port, tt.addr, tt.port)
+			}
+		})
+	}
+	if _, _, err := parseProcAddr("zz:0035"); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
+
+func TestListeners(t *testing.T) {
+	h := fakeHost(t, map[string]string{
+		"/proc/net/tcp": procNetHeader +
+			"   0: 3500007F:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000   101        0 100 1 0000000000000000 100 0 0 10 5\n" +
+			"   1: 0100007F:9C40 0100007F:0035 01 00000000:00000000 00:00000000 00000000  1000        0 101 1 0000000000000000 20 4 30 10 -1\n",
+		"/proc/net/udp": procNetHeader +
+			"   0: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 102 2 0000000000000000 0\n",
+	})
+	socks, err := h.listeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The established TCP connection is not a listener.
+	want := []socket{
+		{Proto: "tcp", Addr: netip.MustParseAddr("127.0.0.53"), Port: 53, UID: "101", Inode: "100"},
+		{Proto: "udp", Addr: netip.MustParseAddr("127.0.0.53"), Port: 53, UID: "101", Inode: "102"},
+	}
+	if len(socks) != len(want) {
+		t.Fatalf("listeners = %v, want %v", socks, want)
+	}
+	for i := range want {
+		if socks[i] != want[i] {
+			t.Errorf("listener %d = %v, want %v", i, socks[i], want[i])
+		}
+	}
+
+	if _, err := fakeHost(t, nil).listeners(); err == nil {
+		t.Error("expected an error without any /proc/net table")
+	}
+}
+
+func TestResolvedStubListener(t *testing.T) {
+	running := map[string]string{"/run/systemd/resolve/": ""}
+	with := func(files map[string]string) map[string]string {
+		out := map[string]string{}
+		for k, v := range running {
+			out[k] = v
+		}
+		for k, v := range files {
+			out[k] = v
+		}
+		return out
+	}
+	tests := []struct {
+		name   string
+		files  map[string]string
+		stub   bool
+		source string
+	}{
+		{"not running", map[string]string{"/etc/systemd/resolved.conf": "[Resolve]\nDNSStubListener=yes\n"}, false, ""},
+		{"default", running, true, ""},
+		{"commented out", with(map[string]string{"/etc/systemd/resolved.conf": "[Resolve]\n#DNSStubListener=yes\n"}), true, ""},
+		{"disabled", with(map[string]string{"/etc/systemd/resolved.conf": "[Resolve]\nDNSStubListener=no\n"}), false, "/etc/systemd/resolved.conf"},
+		{"other section", with(map[string]string{"/etc/systemd/resolved.conf": "[Other]\nDNSStubListener=no\n"}), true, ""},
+		{"drop-in disables", with(map[string]string{
+			"/etc/systemd/resolved.conf":                "[Resolve]\nDNSStubListener=yes\n",
+			"/etc/systemd/resolved.conf.d/no-stub.conf": "[Resolve]\nDNSStubListener=no\n",
+		}), false, "/etc/systemd/resolved.conf.d/no-stub.conf"},
+		{"later drop-in wins", with(map[string]string{
+			"/etc/systemd/resolved.conf.d/10-off.conf":    "[Resolve]\nDNSStubListener=no\n",
+			"/usr/lib/systemd/resolved.conf.d/20-on.conf": "[Resolve]\nDNSStubListener=udp\n",
+		}), true, "/usr/lib/systemd/resolved.conf.d/20-on.conf"},
+		{"etc masks same name", with(map[string]string{
+			"/usr/lib/systemd/resolved.conf.d/stub.conf": "[Resolve]\nDNSStubListener=yes\n",
+			"/etc/systemd/resolved.conf.d/stub.conf":     "[Resolve]\nDNSStubListener=false\n",
+		}), false, "/etc/systemd/resolved.conf.d/stub.conf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stub, source := fakeHost(t, tt.files).resolvedStubListener()
+			if stub != tt.stub || source != tt.source {
+				t.Errorf("got (%v, %q), want (%v, %q)", stub, source, tt.stub, tt.source)
+			}
+		})
+	}
+}
+
+func TestCheckDNSPort(t *testing.T) {
+	resolved := map[string]string{"/run/systemd/resolve/": ""}
+	tests := []struct {
+		name     string
+		path     string
+		bindAddr string
+		files    map[string]string
+		blocking bool
+	}{
+		{"free port 53 passes", "pihole/dns-port", "", nil, false},
+		{"resolved stub blocks", "pihole/dns-port", "", resolved, true},
+		{"LAN address with resolved stub passes", "pihole/dns-port", "192.168.1.10", resolved, false},
+		{"other path is not checked", "pihole/web-port", "", resolved, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := config.NewTree()
+			tree.Set(tt.path, &config.Value{Val: 53})
+			tree.Set("pihole/dns-bind-address", &config.Value{Val: tt.bindAddr})
+			results := checkDNSPort(fakeHost(t, tt.files), tt.path, tree)
+			if got := len(results) > 0 && results[0].Severity == config.Blocking; got != tt.blocking {
+				t.Errorf("blocking = %v, want %v (%v)", got, tt.blocking, results)
+			}
+		})
+	}
+}
+
+func TestDNSPortConflicts(t *testing.T) {
+	stubSockets := procNetHeader +
+		"   0: 3500007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000   101        0 200 2 0000000000000000 0\n"
+	tests := []struct {
+		name     string
+		port     int
+		addr     string
+		files    map[string]string
+		severity config.Severity // of every result
+		want     []string
+	}{
+		{"free", 53, "", map[string]string{"/proc/net/udp": procNetHeader}, 0, nil},
+		{"resolved stub", 53, "", map[string]string{
+			"/run/systemd/resolve/": "",
+			"/proc/net/udp":         stubSockets,
+		}, config.Blocking, []string{"DNSStubListener, set by its default configuration"}},
+		{"resolved stub disabled", 53, "", map[string]string{
+			"/run/systemd/resolve/":                     "",
+			"/etc/systemd/resolved.conf.d/no-stub.conf": "[Resolve]\nDNSStubListener=no\n",
+			"/proc/net/udp":                             procNetHeader,
+		}, 0, nil},
+		{"stub on another port", 5300, "", map[string]string{
+			"/run/systemd/resolve/": "",
+			"/proc/net/udp":         stubSockets,
+		}, 0, nil},
+		{"dnsmasq", 53, "", map[string]string{
+			"/proc/net/udp": procNetHeader + "   0: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 300 2 0000000000000000 0\n",
+			"/proc/42/fd/7": "->socket:[300]",
+			"/proc/42/comm": "dnsmasq\n",
+			"/proc/43/fd/1": "->/dev/null",
+			"/proc/net/tcp": procNetHeader,
+		}, config.Blocking, []string{"Port 53/udp is already in use on 0.0.0.0 by dnsmasq"}},
+		{"unknown owner", 5300, "", map[string]string{
+			"/proc/net/tcp6": procNetHeader + "   0: 00000000000000000000000000000000:14B4 00000000000000000000000000000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 500 1 0000000000000000 100 0 0 10 0\n",
+		}, config.Warning, []string{"Port 5300/tcp is in use on :: by a process of user 1000"}},
+		{"running pihole", 53, "", map[string]string{
+			"/proc/net/tcp": procNetHeader + "   0: 00000000:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 400 1 0000000000000000 100 0 0 10 0\n",
+			"/proc/9/fd/4":  "->socket:[400]",
+			"/proc/9/comm":  "docker-proxy\n",
+		}, 0, nil},
+		{"stub with lan address", 53, "192.168.1.10", map[string]string{
+			"/run/systemd/resolve/": "",
+			"/proc/net/udp":         stubSockets,
+		}, 0, nil},
+		{"stub with wildcard address", 53, "0.0.0.0", map[string]string{
+			"/run/systemd/resolve/": "",
+			"/proc/net/udp":         stubSockets,
+		}, config.Blocking, []string{"DNSStubListener"}},
+		{"listener on another address", 53, "192.168.1.10", map[string]string{
+			"/proc/net/udp": procNetHeader + "   0: 0100007F:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 300 2 0000000000000000 0\n",
+		}, 0, nil},
+		{"listener on the same address", 53, "192.168.1.10", map[string]string{
+			"/proc/net/udp": procNetHeader + "   0: 0A01A8C0:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 300 2 0000000000000000 0\n",
+			"/proc/42/fd/7": "->socket:[300]",
+			"/proc/42/comm": "unbound\n",
+		}, config.Blocking, []string{"Port 53/udp is already in use on 192.168.1.10"}},
+		{"root owner hidden", 53, "", map[string]string{
+			"/proc/net/udp": procNetHeader + "   0: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 400 2 0000000000000000 0\n",
+		}, config.Info, []string{"most likely docker-proxy"}},
+		{"no proc", 53, "", nil, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := fakeHost(t, tt.files).dnsPortConflicts(tt.port, tt.addr)
+			if len(results) != len(tt.want) {
+				t.Fatalf("results %v, want %d", results, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if results[i].Severity != tt.severity || !strings.Contains(results[i].Message, want) {
+					t.Errorf("result %v, want a %v one containing %q", results[i], tt.severity, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDNSPortConflictsUnreadableFds(t *testing.T) {
+	h := fakeHost(t, map[string]string{
+		"/proc/net/tcp": procNetHeader + "   0: 00000000:0035 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 400 1 0000000000000000 100 0 0 10 0\n",
+		"/proc/9/fd/4":  "->socket:[400]",
+		"/proc/9/comm":  "docker-proxy\n",
+	})
+	// Like /proc/<pid>/fd of a root process for other users.
+	if err := os.Chmod(h.path("/proc/9/fd"), 0); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(h.path("/proc/9/fd"), 0o755) })
+	results := h.dnsPortConflicts(53, "")
+	if os.Getuid() == 0 {
+		// root reads the fds anyway and finds docker-proxy.
+		if len(results) > 0 {
+			t.Errorf("unexpected results as root: %v", results)
+		}
+		return
+	}
+	if len(results) != 1 || results[0].Severity != config.Info {
+		t.Errorf("results %v, want one Info result", results)
+	}
+}
+
+func TestCheckRenderDevice(t *testing.T) {
+	tests := []struct {
+		name     string
+		on       bool
+		files    map[string]string
+		blocking bool
+	}{
+		{"off", false, nil, false},
+		{"on with device", true, map[string]string{"/dev/dri/": ""}, false},
+		{"on without device", true, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := config.NewTree()
+			tree.Set("plex/hardware-transcoding", &config.Value{Val: tt.on})
+			results := checkRenderDevice(fakeHost(t, tt.files), "plex/hardware-transcoding", tree)
+			if got := len(results) > 0 && results[0].Severity == config.Blocking; got != tt.blocking {
+				t.Errorf("blocking = %v, want %v (%v)", got, tt.blocking, results)
+			}
+		})
+	}
+}
+
+func TestCheckBindAddress(t *testing.T) {
+	h := fakeHost(t, nil)
+	h.interfaceAddrs = func() ([]net.Addr, error) {
+		return []net.Addr{
+			&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+			&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("fd00::10"), Mask: net.CIDRMask(64, 128)},
+		}, nil
+	}
+	tests := []struct {
+		path     string
+		addr     string
+		blocking bool
+	}{
+		{"core/bind-address", "", false},
+		{"core/bind-address", "0.0.0.0", false},
+		{"core/bind-address", "192.168.1.10", false},
+		{"core/bind-address", "192.168.1.11", true},
+		{"pihole/web-bind-address", "fd00::10", false},
+		{"nginx-proxy-manager/admin-bind-address", "127.0.0.1", false},
+		{"nginx-proxy-manager/admin-bind-address", "10.0.0.1", true},
+		{"core/domain", "10.0.0.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+"="+tt.addr, func(t *testing.T) {
+			tree := config.NewTree()
+			tree.Set(tt.path, &config.Value{Val: tt.addr})
+			results := checkBindAddress(h, tt.path, tree)
+			if got := len(results) > 0 && results[0].Severity == config.Blocking; got != tt.blocking {
+				t.Errorf("blocking = %v, want %v (%v)", got, tt.blocking, results)
+			}
+		})
+	}
+}
+
+func TestCheckHostPaths(t *testing.T) {
+	h := fakeHost(t, map[string]string{
+		"/srv/homeserver/":  "",
+		"/srv/not-a-dir":    "file",
+		"/srv/read-only/":   "",
+		"/mnt/media/movies": "file",
+	})
+	if err := os.Chmod(h.path("/srv/read-only"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		val      string
+		severity config.Severity
+		want     string // "" for no result
+	}{
+		{"core/data-root", "/srv/homeserver", 0, ""},
+		{"core/data-root", "/srv/missing", config.Warning, "does not exist on this host"},
+		{"core/backup-dir", "/srv/backups", config.Info, "backup.sh creates it"},
+		{"core/data-root", "/srv/not-a-dir", config.Blocking, "is not a directory"},
+		{"plex/media-movies", "/mnt/media/movies", config.Blocking, "is not a directory"},
+		{"plex/media-tv", "relative", 0, ""},
+		{"core/timezone", "/srv/missing", 0, ""},
+	}
+	if os.Getuid() != 0 {
+		tests = append(tests, struct {
+			path     string
+			val      string
+			severity config.Severity
+			want     string
+		}{"core/backup-dir", "/srv/read-only", config.Warning, "is not writable"})
+	}
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.val, func(t *testing.T) {
+			tree := config.NewTree()
+			tree.Set(tt.path, &config.Value{Val: tt.val})
+			results := checkHostPaths(h, tt.path, tree)
+			if tt.want == "" {
+				if len(results) > 0 {
+					t.Errorf("unexpected results %v", results)
+				}
+				return
+			}
+			if len(results) != 1 || results[0].Severity != tt.severity || !strings.Contains(results[0].Message, tt.want) {
+				t.Errorf("results = %v, want one %s containing %q", results, tt.severity, tt.want)
+			}
+		})
+	}
+	if entries, _ := os.ReadDir(h.path("/srv/homeserver")); len(entries) > 0 {
+		t.Errorf("the write test left %v behind", entries)
+	}
+}
+
+func TestHostChecksInValidate(t *testing.T) {
+	p := newHomeserverPlugin()
+	tree := config.NewTree()
+	tree.Set("plex/hardware-transcoding", &config.Value{Val: true})
+
+	results, err := p.Validate(t.Context(), "plex/hardware-transcoding", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) > 0 {
+		t.Errorf("host checks ran while disabled: %v", results)
+	}
+
+	p.host = fakeHost(t, nil)
+	results, err = p.Validate(t.Context(), "plex/hardware-transcoding", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Message, "/dev/dri") {
+		t.Errorf("results = %v, want the /dev/dri check", results)
+	}
+}
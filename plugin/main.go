@@ -2,7 +2,12 @@
 // configuration values for a home server Docker Compose deployment.
 //
 // It serves values for the following components: core settings, PiHole,
-// Plex, Nextcloud, MariaDB, Redis, and Nginx Proxy Manager.
+// Plex, Nextcloud, MariaDB, Redis, and Nginx Proxy Manager. The same
+// binary also serves a transform plugin that recomputes computed values
+// once zhi has merged the saved values into the tree.
+//
+// Run directly with a command, e.g. "zhi-config-homeserver schema", it
+// works as a standalone tool instead.
 package main
 
 import (
@@ -13,9 +18,14 @@ import (
 
 	"github.com/MrWong99/zhi/pkg/zhiplugin"
 	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"github.com/MrWong99/zhi/pkg/zhiplugin/transform"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:], os.Stdout, os.Stderr))
+	}
+
 	level := hclog.LevelFromString(os.Getenv("ZHI_LOG_LEVEL"))
 	if level == hclog.NoLevel {
 		level = hclog.Info
@@ -27,10 +37,16 @@ func main() {
 	})
 	logger.Info("starting homeserver config plugin")
 
+	p := newHomeserverPlugin()
+	if p.loadErr != nil {
+		logger.Error("cannot load values overlay", "error", p.loadErr)
+		os.Exit(1)
+	}
 	goplugin.Serve(&goplugin.ServeConfig{
 		HandshakeConfig: zhiplugin.Handshake,
 		Plugins: map[string]goplugin.Plugin{
-			"config": &config.GRPCPlugin{Impl: newHomeserverPlugin()},
+			"config":    &config.GRPCPlugin{Impl: p},
+			"transform": &transform.GRPCPlugin{Impl: computeTransform{p: p}},
 		},
 		GRPCServer: goplugin.DefaultGRPCServer,
 		Logger:     logger,
@@ -0,0 +1,278 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/pluginopts"
+	"gopkg.in/yaml.v3"
+)
+
+// valuesYAML is the manifest of the workspace's configuration values.
+//
+//go:embed values.yaml
+var valuesYAML []byte
+
+// valueDefs contains all configuration values for the home server
+// workspace, as declared in values.yaml.
+var valueDefs = mustParseValueDefs(valuesYAML)
+
+// valueSpec is a value definition as written in values.yaml or an overlay
+// file. Go-only parts of a ValueDef -- derived defaults, computed values
+// and validators -- are referenced by name and resolved by toDef.
+type valueSpec struct {
+	Path           string      `yaml:"path"`
+	Default        any         `yaml:"default"`
+	Derive         *deriveSpec `yaml:"derive"`
+	Type           string      `yaml:"type"`
+	Section        string      `yaml:"section"`
+	DisplayName    string      `yaml:"displayName"`
+	Description    string      `yaml:"description"`
+	Placeholder    string      `yaml:"placeholder"`
+	Password       bool        `yaml:"password"`
+	AutoGenerate   bool        `yaml:"autoGenerate"`
+	Required       bool        `yaml:"required"`
+	RequiredWith   []string    `yaml:"requiredWith"`
+	ReadOnly       bool        `yaml:"readOnly"`
+	Hidden         bool        `yaml:"hidden"`
+	SelectFrom     []string    `yaml:"selectFrom"`
+	SelectFromList string      `yaml:"selectFromList"`
+	Min            *int        `yaml:"min"`
+	Max            *int        `yaml:"max"`
+	Pattern        string      `yaml:"pattern"`
+	Format         string      `yaml:"format"`
+	Separator      string      `yaml:"separator"`
+	SizeSyntax     string      `yaml:"sizeSyntax"`
+	Compute        string      `yaml:"compute"`
+	Validate       []string    `yaml:"validate"`
+}
+
+// deriveSpec names a Derived default, see deriveFuncs.
+type deriveSpec struct {
+	From     string `yaml:"from"`
+	Format   string `yaml:"format"`
+	Fallback string `yaml:"fallback"`
+}
+
+// valueLists holds the built-in lists selectFromList can refer to.
+var valueLists = map[string][]string{
+	"timezones": timezones,
+}
+
+// zeroValues are the defaults of values that declare none.
+var zeroValues = map[string]any{
+	"string": "",
+	"size":   "",
+	"int":    0,
+	"bool":   false,
+}
+
+// mustParseValueDefs parses the embedded manifest. It panics on errors,
+// which the tests catch before a release.
+func mustParseValueDefs(data []byte) []ValueDef {
+	specs, err := mergeValueSpecs(nil, data)
+	if err != nil {
+		panic(fmt.Sprintf("values.yaml: %v", err))
+	}
+	defs, err := buildValueDefs(specs)
+	if err != nil {
+		panic(fmt.Sprintf("values.yaml: %v", err))
+	}
+	return defs
+}
+
+// mergeValueSpecs adds the values declared in data to specs. A value
+// whose path is already in specs is updated field by field, so an
+// overlay only needs to list what it changes.
+func mergeValueSpecs(specs []valueSpec, data []byte) ([]valueSpec, error) {
+	var m struct {
+		Values []yaml.Node `yaml:"values"`
+	}
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	index := make(map[string]int, len(specs))
+	for i, s := range specs {
+		index[s.Path] = i
+	}
+	seen := map[string]bool{}
+	for _, node := range m.Values {
+		var key struct {
+			Path string `yaml:"path"`
+		}
+		if err := node.Decode(&key); err != nil {
+			return nil, fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		if key.Path == "" {
+			return nil, fmt.Errorf("line %d: value without path", node.Line)
+		}
+		if seen[key.Path] {
+			return nil, fmt.Errorf("line %d: %s is declared twice", node.Line, key.Path)
+		}
+		seen[key.Path] = true
+		i, ok := index[key.Path]
+		if !ok {
+			specs = append(specs, valueSpec{})
+			i = len(specs) - 1
+			index[key.Path] = i
+		}
+		if err := node.Decode(&specs[i]); err != nil {
+			return nil, fmt.Errorf("%s: %w", key.Path, err)
+		}
+		// A plain default replaces a derived one.
+		if hasKey(&node, "default") && !hasKey(&node, "derive") {
+			specs[i].Derive = nil
+		}
+	}
+	return specs, nil
+}
+
+// hasKey reports whether the mapping node sets key.
+func hasKey(node *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return true
+		}
+	}
+	return false
+}
+
+// buildValueDefs resolves specs into ValueDefs.
+func buildValueDefs(specs []valueSpec) ([]ValueDef, error) {
+	defs := make([]ValueDef, 0, len(specs))
+	var errs []error
+	for _, s := range specs {
+		d, err := s.toDef()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.Path, err))
+			continue
+		}
+		defs = append(defs, d)
+	}
+	return defs, errors.Join(errs...)
+}
+
+// toDef resolves the named references of s and checks that the result is
+// a consistent definition.
+func (s *valueSpec) toDef() (ValueDef, error) {
+	d := ValueDef{
+		Path: s.Path, Default: s.Default,
+		Section: s.Section, DisplayName: s.DisplayName,
+		Description: s.Description, Type: s.Type,
+		Placeholder: s.Placeholder, Password: s.Password,
+		AutoGenerate: s.AutoGenerate, Required: s.Required || len(s.RequiredWith) > 0,
+		RequiredWith: s.RequiredWith, ReadOnly: s.ReadOnly, Hidden: s.Hidden,
+		SelectFrom: s.SelectFrom, Min: s.Min, Max: s.Max, Format: s.Format,
+		Separator: s.Separator, SizeSyntax: s.SizeSyntax,
+	}
+	zero, ok := zeroValues[s.Type]
+	if !ok {
+		return d, fmt.Errorf("unknown type %q", s.Type)
+	}
+	component, _, _ := strings.Cut(s.Path, "/")
+
+	switch {
+	case s.Derive != nil:
+		fn, ok := deriveFuncs[s.Derive.From]
+		if !ok {
+			return d, fmt.Errorf("unknown derive source %q", s.Derive.From)
+		}
+		d.Default = fn(component, s.Derive.Format, s.Derive.Fallback)
+	case s.Default == nil:
+		d.Default = zero
+	default:
+		// The YAML type of a default may differ from the declared type,
+		// e.g. an unquoted image tag 11; coerce it like a value from Set.
+		val, err := coerceValue(&d, s.Default)
+		if err != nil {
+			return d, fmt.Errorf("invalid default: %w", err)
+		}
+		d.Default = val
+	}
+
+	if s.SelectFromList != "" {
+		list, ok := valueLists[s.SelectFromList]
+		if !ok {
+			return d, fmt.Errorf("unknown list %q", s.SelectFromList)
+		}
+		d.SelectFrom = list
+	}
+	if s.Pattern != "" {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return d, fmt.Errorf("invalid pattern: %w", err)
+		}
+		d.Pattern = re
+	}
+	if _, ok := formats[s.Format]; s.Format != "" && !ok {
+		return d, fmt.Errorf("unknown format %q", s.Format)
+	}
+	if _, ok := sizeSyntaxes[s.SizeSyntax]; s.SizeSyntax != "" && !ok {
+		return d, fmt.Errorf("unknown size syntax %q", s.SizeSyntax)
+	}
+
+	if s.Compute != "" {
+		fn, ok := computeFuncs[s.Compute]
+		if !ok {
+			return d, fmt.Errorf("unknown compute function %q", s.Compute)
+		}
+		d.Compute = fn(component)
+	}
+
+	switch {
+	case len(s.RequiredWith) > 0:
+		d.Validators = append(d.Validators, requiredWith(s.RequiredWith...))
+	case s.Required:
+		d.Validators = append(d.Validators, validateRequired)
+	}
+	for _, name := range s.Validate {
+		fn, ok := namedValidators[name]
+		if !ok {
+			return d, fmt.Errorf("unknown validator %q", name)
+		}
+		d.Validators = append(d.Validators, fn)
+	}
+	return d, nil
+}
+
+// valuesOverlayFile returns the overlay file configured through the
+// "values-overlay" plugin option or the ZHI_VALUES_OVERLAY environment
+// variable, resolved against the workspace directory. It returns "" if
+// none is configured.
+func valuesOverlayFile(workspaceDir string) string {
+	f := pluginopts.String(pluginopts.Options(), "values-overlay", "ZHI_VALUES_OVERLAY", "")
+	if f == "" || filepath.IsAbs(f) {
+		return f
+	}
+	return filepath.Join(workspaceDir, f)
+}
+
+// loadValueDefs returns the embedded value definitions with the overlay
+// file applied, if one is configured.
+func loadValueDefs(workspaceDir string) ([]ValueDef, error) {
+	file := valuesOverlayFile(workspaceDir)
+	if file == "" {
+		return valueDefs, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return valueDefs, err
+	}
+	specs, err := mergeValueSpecs(nil, valuesYAML)
+	if err != nil {
+		return valueDefs, err
+	}
+	if specs, err = mergeValueSpecs(specs, data); err != nil {
+		return valueDefs, fmt.Errorf("%s: %w", file, err)
+	}
+	defs, err := buildValueDefs(specs)
+	if err != nil {
+		return valueDefs, fmt.Errorf("%s: %w", file, err)
+	}
+	return defs, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestMergeValueSpecsOverlay(t *testing.T) {
+	specs, err := mergeValueSpecs(nil, valuesYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	overlay := `
+values:
+  - path: plex/web-port
+    default: 32401
+  - path: nextcloud/trusted-domains
+    default: cloud.lan
+  - path: plex/media-photos
+    default: /mnt/media/photos
+    type: string
+    section: Media Libraries
+    displayName: Photos Path
+    validate: [optional-abs-path]
+`
+	specs, err = mergeValueSpecs(specs, []byte(overlay))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs, err := buildValueDefs(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byPath := map[string]*ValueDef{}
+	for i := range defs {
+		byPath[defs[i].Path] = &defs[i]
+	}
+
+	port := byPath["plex/web-port"]
+	if port.Default != 32401 {
+		t.Errorf("plex/web-port default = %v, want 32401", port.Default)
+	}
+	if port.Section != "Network" || port.Max == nil {
+		t.Error("overlay dropped fields it did not set")
+	}
+	if d := byPath["nextcloud/trusted-domains"].Default; d != "cloud.lan" {
+		t.Errorf("trusted-domains default = %v, want the plain overlay default", d)
+	}
+	photos, ok := byPath["plex/media-photos"]
+	if !ok {
+		t.Fatal("overlay value not added")
+	}
+	if len(photos.Validators) != 1 {
+		t.Errorf("plex/media-photos has %d validators, want 1", len(photos.Validators))
+	}
+	if len(defs) != len(valueDefs)+1 {
+		t.Errorf("got %d defs, want %d", len(defs), len(valueDefs)+1)
+	}
+}
+
+func TestValueSpecErrors(t *testing.T) {
+	tests := []struct {
+		name, manifest, want string
+	}{
+		{"missing path", "values:\n  - type: string\n", "value without path"},
+		{"duplicate path", "values:\n  - {path: a/b, type: string}\n  - {path: a/b, type: string}\n", "declared twice"},
+		{"unknown type", "values:\n  - {path: a/b, type: float}\n", `unknown type "float"`},
+		{"invalid default", "values:\n  - {path: a/b, type: int, default: abc}\n", "invalid default"},
+		{"invalid pattern", "values:\n  - {path: a/b, type: string, pattern: '('}\n", "invalid pattern"},
+		{"unknown format", "values:\n  - {path: a/b, type: string, format: ipv9}\n", `unknown format "ipv9"`},
+		{"unknown list", "values:\n  - {path: a/b, type: string, selectFromList: colors}\n", `unknown list "colors"`},
+		{"unknown derive", "values:\n  - {path: a/b, type: string, derive: {from: moon}}\n", `unknown derive source "moon"`},
+		{"unknown compute", "values:\n  - {path: a/b, type: string, compute: magic}\n", `unknown compute function "magic"`},
+		{"unknown validator", "values:\n  - {path: a/b, type: string, validate: [nonsense]}\n", `unknown validator "nonsense"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			specs, err := mergeValueSpecs(nil, []byte(tt.manifest))
+			if err == nil {
+				_, err = buildValueDefs(specs)
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueSpecDefaults(t *testing.T) {
+	specs, err := mergeValueSpecs(nil, []byte(`
+values:
+  - {path: a/tag, type: string, default: 11}
+  - {path: a/port, type: int, default: "8080"}
+  - {path: a/flag, type: bool}
+  - {path: a/host, type: string, required: true}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defs, err := buildValueDefs(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{"11", 8080, false, ""}
+	for i, d := range defs {
+		if d.Default != want[i] {
+			t.Errorf("%s default = %#v, want %#v", d.Path, d.Default, want[i])
+		}
+	}
+	if res, _ := defs[3].Validators[0](config.Value{Val: ""}, nil); len(res) != 1 {
+		t.Error("required value without a validator")
+	}
+}
+
+func TestPluginValuesOverlay(t *testing.T) {
+	dir := t.TempDir()
+	overlay := "values:\n  - path: pihole/web-port\n    default: 8054\n"
+	if err := os.WriteFile(filepath.Join(dir, "values.local.yaml"), []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZHI_WORKSPACE", dir)
+	t.Setenv("ZHI_VALUES_OVERLAY", "values.local.yaml")
+
+	p := newHomeserverPlugin()
+	if p.loadErr != nil {
+		t.Fatal(p.loadErr)
+	}
+	v, _, _ := p.Get(t.Context(), "pihole/web-port")
+	if v.Val != 8054 {
+		t.Errorf("pihole/web-port = %v, want 8054", v.Val)
+	}
+
+	// A broken overlay is reported and the embedded values are served.
+	t.Setenv("ZHI_VALUES_OVERLAY", "missing.yaml")
+	p = newHomeserverPlugin()
+	if p.loadErr == nil {
+		t.Error("expected an error for a missing overlay")
+	}
+	v, _, _ = p.Get(t.Context(), "pihole/web-port")
+	if v.Val != 8053 {
+		t.Errorf("pihole/web-port = %v, want the embedded default", v.Val)
+	}
+}
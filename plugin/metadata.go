@@ -1,23 +1,46 @@
 package main
 
-import "github.com/MrWong99/zhi/pkg/zhiplugin/config"
+import (
+	"regexp"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
 
 // ValueDef defines a configuration value with its default and metadata.
 // This reduces the boilerplate of repeating the same metadata label keys
-// across all config values.
+// across all config values. ValueDefs are declared in values.yaml and
+// built by valueSpec.toDef.
 type ValueDef struct {
 	Path        string // slash-delimited config path, e.g. "core/timezone"
-	Default     any    // default value
+	Default     any    // default value, or a Derived default computed from other values
 	Section     string // ui.section
 	DisplayName string // ui.displayName
 	Description string // core.description
-	Type        string // core.type (string, int, bool)
+	Type        string // core.type (string, int, bool, size)
 
 	// Optional fields -- zero values mean "not set"
-	Placeholder string   // ui.placeholder
-	Password    bool     // ui.password
-	Required    bool     // config.required
-	SelectFrom  []string // ui.enum (dropdown selection)
+	Placeholder  string         // ui.placeholder
+	Password     bool           // ui.password
+	AutoGenerate bool           // fill an empty default with a random secret (config.generated)
+	Required     bool           // config.required
+	RequiredWith []string       // components that make the value required; none means always
+	ReadOnly     bool           // ui.readonly
+	Hidden       bool           // ui.hidden (kept by the plugin, not edited by users)
+	SelectFrom   []string       // ui.enum (dropdown selection)
+	Min          *int           // config.min (inclusive lower bound for int values)
+	Max          *int           // config.max (inclusive upper bound for int values)
+	Pattern      *regexp.Regexp // ui.pattern (regular expression string values must match)
+	Format       string         // config.format (named format, see formats)
+	Separator    string         // config.separator (value is a list; Pattern and Format apply per element)
+	SizeSyntax   string         // config.sizeSyntax (notation size values are rendered in, see sizeSyntaxes)
+
+	// Compute makes the value read-only and computes it from the other
+	// values on every Get (ui.readonly, store.ephemeral).
+	Compute func(tree config.TreeReader) any
+
+	// Validators run after defValidators for this path. They come from the
+	// required, requiredWith and validate fields of the manifest.
+	Validators []validatorFunc
 }
 
 // ToValue converts a ValueDef to a config.Value with the standard
@@ -29,6 +52,22 @@ func (d *ValueDef) ToValue() *config.Value {
 		"core.description": d.Description,
 		"core.type":        d.Type,
 	}
+	// Sizes travel as strings; zhi only knows the primitive core.types.
+	format := d.Format
+	if d.Type == "size" {
+		md["core.type"] = "string"
+		format = "size"
+		if d.SizeSyntax != "" {
+			md["config.sizeSyntax"] = d.SizeSyntax
+		}
+	}
+	if d.Compute != nil {
+		md["ui.readonly"] = true
+		md["store.ephemeral"] = true
+	}
+	if dd, ok := d.Default.(Derived); ok {
+		md["config.derivedFrom"] = dd.Sources
+	}
 	if d.Placeholder != "" {
 		md["ui.placeholder"] = d.Placeholder
 	}
@@ -38,11 +77,36 @@ func (d *ValueDef) ToValue() *config.Value {
 	if d.Required {
 		md["config.required"] = true
 	}
+	if d.ReadOnly {
+		md["ui.readonly"] = true
+	}
+	if d.Hidden {
+		md["ui.hidden"] = true
+	}
 	if len(d.SelectFrom) > 0 {
 		md["ui.enum"] = d.SelectFrom
 	}
+	if d.Min != nil {
+		md["config.min"] = *d.Min
+	}
+	if d.Max != nil {
+		md["config.max"] = *d.Max
+	}
+	if format != "" {
+		md["config.format"] = format
+	}
+	if d.Separator != "" {
+		md["config.separator"] = d.Separator
+	}
+	// An explicit pattern wins over the one implied by the format. List
+	// values get no ui.pattern since it would apply to the whole string.
+	if d.Pattern != nil {
+		md["ui.pattern"] = d.Pattern.String()
+	} else if f, ok := formats[format]; ok && f.Pattern != "" && d.Separator == "" {
+		md["ui.pattern"] = f.Pattern
+	}
 	return &config.Value{
-		Val:      d.Default,
+		Val:      d.defaultValue(config.NewTree()),
 		Metadata: md,
 	}
 }
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// schemaVersion is the version of the value layout in valueDefs. Bump it
+// and register a migration whenever a path is renamed or the format of a
+// value changes, so saved configurations are carried over.
+//
+// Version history:
+//
+//	1  initial layout (configurations saved before versioning)
+//	2  list separators normalised: ';' for upstream DNS, ' ' for trusted domains
+const schemaVersion = 2
+
+// migration maps a value of an older schema to the current one.
+type migration struct {
+	Version int                       // schema version that introduced the change
+	From    string                    // old path; empty if the path did not change
+	Path    string                    // current path
+	Convert func(val any) (any, bool) // rewrites an old value format and reports whether it changed; nil keeps the value
+	Note    string                    // what changed, for the migration report
+}
+
+// migrations lists all migrations in the order they are applied.
+var migrations = []migration{
+	{Version: 2, Path: "pihole/upstream-dns", Convert: resplit(";"), Note: "upstream DNS servers are now separated by ';'"},
+	{Version: 2, Path: "nextcloud/trusted-domains", Convert: resplit(" "), Note: "trusted domains are now separated by spaces"},
+}
+
+// listSplitRe matches the separators lists were written with before
+// schema 2: commas, semicolons and whitespace.
+var listSplitRe = regexp.MustCompile(`[,;\s]+`)
+
+// resplit returns a Convert function that rewrites a list written with
+// any of the separators matched by listSplitRe to use sep.
+func resplit(sep string) func(any) (any, bool) {
+	return func(val any) (any, bool) {
+		s, ok := val.(string)
+		if !ok {
+			return val, false
+		}
+		var elems []string
+		for _, e := range listSplitRe.Split(s, -1) {
+			if e != "" {
+				elems = append(elems, e)
+			}
+		}
+		out := strings.Join(elems, sep)
+		return out, out != s
+	}
+}
+
+// migrationRecord describes a migration applied to a value.
+type migrationRecord struct {
+	Path    string // current path of the value
+	From    string // path the value was read from, if it was renamed
+	Version int    // schema version the migration belongs to
+	Note    string
+}
+
+// String renders the record for reports and validation messages.
+func (r migrationRecord) String() string {
+	if r.From != "" {
+		return fmt.Sprintf("%s: moved from %s (schema %d)", r.Path, r.From, r.Version)
+	}
+	return fmt.Sprintf("%s: %s (schema %d)", r.Path, r.Note, r.Version)
+}
+
+// deprecatedPaths returns the old paths of renamed values.
+func deprecatedPaths() []string {
+	var paths []string
+	for _, m := range migrations {
+		if m.From != "" && !slices.Contains(paths, m.From) {
+			paths = append(paths, m.From)
+		}
+	}
+	return paths
+}
+
+// migrateValue applies the migrations newer than fromVersion to a value
+// arriving at path. Renames are resolved first, so format conversions
+// registered for the current path apply to values read from an old path
+// too. It returns the current path, the converted value and a record for
+// every migration that changed something.
+func migrateValue(path string, val any, fromVersion int) (string, any, []migrationRecord) {
+	path, records := renamedPath(path, fromVersion)
+	for _, m := range migrations {
+		if m.Version <= fromVersion || m.Path != path || m.Convert == nil {
+			continue
+		}
+		if converted, changed := m.Convert(val); changed {
+			val = converted
+			records = append(records, migrationRecord{Path: path, Version: m.Version, Note: m.Note})
+		}
+	}
+	return path, val, records
+}
+
+// renamedPath follows the renames newer than fromVersion from path and
+// returns the current path with a record for every rename.
+func renamedPath(path string, fromVersion int) (string, []migrationRecord) {
+	var records []migrationRecord
+	for _, m := range migrations {
+		if m.Version > fromVersion && m.From != "" && m.From == path {
+			records = append(records, migrationRecord{Path: m.Path, From: path, Version: m.Version, Note: m.Note})
+			path = m.Path
+		}
+	}
+	return path, records
+}
+
+// treeSchemaVersion returns the schema version recorded in tree. A
+// configuration saved before versioning has none, so a missing or
+// default version counts as 1; migrateTree stamps the current version
+// before the tree is saved.
+func treeSchemaVersion(tree config.TreeReader) int {
+	v, ok := tree.Get("core/schema-version")
+	if !ok {
+		return 1
+	}
+	n, ok := toInt(v.Val)
+	if !ok || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// migrateTree migrates the values of a loaded tree from the schema
+// version it records to the current one. A value found under an old path
+// is moved to its new path unless the new path already holds a value
+// other than its default, which then wins as the newer setting. Migrated
+// values are annotated with config.migrated so validation can report
+// them. It returns the applied migrations.
+func (p *homeserverPlugin) migrateTree(tree *config.Tree) []migrationRecord {
+	from := treeSchemaVersion(tree)
+	if from >= schemaVersion {
+		return nil
+	}
+	var all []migrationRecord
+	for _, path := range tree.List() {
+		v, _ := tree.Get(path)
+		newPath, val, records := migrateValue(path, v.Val, from)
+		if len(records) == 0 {
+			continue
+		}
+		if newPath != path {
+			tree.Delete(path)
+			if cur, ok := tree.Get(newPath); ok && !p.isDefault(newPath, cur.Val) {
+				continue
+			}
+		}
+		target, ok := tree.GetPtr(newPath)
+		if !ok {
+			continue
+		}
+		target.Val = val
+		target.Metadata = annotateMigrated(target.Metadata, records)
+		all = append(all, records...)
+	}
+	if v, ok := tree.GetPtr("core/schema-version"); ok {
+		v.Val = schemaVersion
+	}
+	return all
+}
+
+// isDefault reports whether val is the default of path.
+func (p *homeserverPlugin) isDefault(path string, val any) bool {
+	d, ok := p.defs[path]
+	return ok && d.defaultValue(config.NewTree()) == val
+}
+
+// annotateMigrated returns a copy of md with the notes of records added
+// to the config.migrated label.
+func annotateMigrated(md map[string]any, records []migrationRecord) map[string]any {
+	out := maps.Clone(md)
+	if out == nil {
+		out = map[string]any{}
+	}
+	notes := migratedNotes(out)
+	for _, r := range records {
+		notes = append(notes, r.String())
+	}
+	out["config.migrated"] = notes
+	return out
+}
+
+// migratedNotes returns the notes of the config.migrated label of md.
+// Metadata reaches the plugin as JSON through zhi, which turns the
+// []string the plugin stored into []any.
+func migratedNotes(md map[string]any) []string {
+	switch notes := md["config.migrated"].(type) {
+	case []string:
+		return slices.Clone(notes)
+	case []any:
+		out := make([]string, 0, len(notes))
+		for _, n := range notes {
+			if s, ok := toString(n); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
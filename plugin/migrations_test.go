@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// withRename registers a test migration renaming from to path for the
+// duration of the test.
+func withRename(t *testing.T, from, path string) {
+	t.Helper()
+	orig := migrations
+	migrations = append(slices.Clone(orig), migration{Version: schemaVersion, From: from, Path: path, Note: "test rename"})
+	t.Cleanup(func() { migrations = orig })
+}
+
+func TestResplit(t *testing.T) {
+	tests := []struct {
+		in, sep, want string
+		changed       bool
+	}{
+		{"1.1.1.1;8.8.8.8", ";", "1.1.1.1;8.8.8.8", false},
+		{"1.1.1.1,8.8.8.8", ";", "1.1.1.1;8.8.8.8", true},
+		{"1.1.1.1, 8.8.8.8 ;9.9.9.9", ";", "1.1.1.1;8.8.8.8;9.9.9.9", true},
+		{"cloud.example.com,localhost", " ", "cloud.example.com localhost", true},
+		{"localhost", " ", "localhost", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, changed := resplit(tt.sep)(tt.in)
+			if got != tt.want || changed != tt.changed {
+				t.Errorf("resplit(%q) = %q, %v; want %q, %v", tt.in, got, changed, tt.want, tt.changed)
+			}
+		})
+	}
+}
+
+func TestMigrateValue(t *testing.T) {
+	withRename(t, "nextcloud/trusted-domain", "nextcloud/trusted-domains")
+
+	path, val, records := migrateValue("nextcloud/trusted-domain", "a.example.com,localhost", 1)
+	if path != "nextcloud/trusted-domains" || val != "a.example.com localhost" {
+		t.Errorf("migrateValue = %q, %q", path, val)
+	}
+	if len(records) != 2 {
+		t.Errorf("got %d records, want rename and format: %v", len(records), records)
+	}
+
+	// Migrations at or below the source version are skipped.
+	if _, _, records := migrateValue("pihole/upstream-dns", "1.1.1.1,8.8.8.8", schemaVersion); len(records) != 0 {
+		t.Errorf("current schema migrated: %v", records)
+	}
+}
+
+func TestPluginSetMigratesValues(t *testing.T) {
+	withRename(t, "pihole/upstream", "pihole/upstream-dns")
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+
+	if err := p.Set(ctx, "pihole/upstream", config.Value{Val: "9.9.9.9;1.1.1.1"}); err != nil {
+		t.Fatal(err)
+	}
+	v, _, _ := p.Get(ctx, "pihole/upstream-dns")
+	if v.Val != "9.9.9.9;1.1.1.1" {
+		t.Errorf("migrated value = %q", v.Val)
+	}
+	if v.Metadata["ui.section"] != "DNS" {
+		t.Errorf("migrated value lost the metadata of its new path: %v", v.Metadata)
+	}
+
+	tree := newDefaultTree(t, nil)
+	tree.Set("pihole/upstream-dns", &v)
+	results, err := p.Validate(ctx, "pihole/upstream-dns", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Severity != config.Info {
+		t.Errorf("expected an info result for the rename, got %v", results)
+	}
+}
+
+func TestPluginSetConvertsFormat(t *testing.T) {
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+
+	if err := p.Set(ctx, "pihole/upstream-dns", config.Value{Val: "1.1.1.1,8.8.8.8"}); err != nil {
+		t.Fatal(err)
+	}
+	v, _, _ := p.Get(ctx, "pihole/upstream-dns")
+	if v.Val != "1.1.1.1;8.8.8.8" {
+		t.Errorf("value = %q, want it converted", v.Val)
+	}
+	if _, ok := v.Metadata["config.migrated"]; !ok {
+		t.Errorf("converted value not marked as migrated: %v", v.Metadata)
+	}
+
+	// A value in the current format is kept as is.
+	if err := p.Set(ctx, "pihole/upstream-dns", config.Value{Val: "9.9.9.9"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ = p.Get(ctx, "pihole/upstream-dns"); v.Val != "9.9.9.9" {
+		t.Errorf("value = %q, want it unchanged", v.Val)
+	}
+}
+
+func TestMigratedNotesFromJSON(t *testing.T) {
+	// zhi passes metadata as JSON, so the notes arrive as []any.
+	md := map[string]any{"config.migrated": []any{"a: first (schema 2)"}}
+	md = annotateMigrated(md, []migrationRecord{{Path: "b", Version: 2, Note: "second"}})
+	notes := migratedNotes(md)
+	if want := []string{"a: first (schema 2)", "b: second (schema 2)"}; !slices.Equal(notes, want) {
+		t.Errorf("notes = %q, want %q", notes, want)
+	}
+
+	p := newHomeserverPlugin()
+	tree := newDefaultTree(t, nil)
+	tree.Set("pihole/upstream-dns", &config.Value{Val: "1.1.1.1;8.8.8.8", Metadata: map[string]any{"config.migrated": []any{"pihole/upstream-dns: test (schema 2)"}}})
+	results, err := p.Validate(context.Background(), "pihole/upstream-dns", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Severity != config.Info {
+		t.Errorf("expected the migration report, got %v", results)
+	}
+}
+
+func TestSchemaVersionOnSave(t *testing.T) {
+	p := newHomeserverPlugin()
+	v, ok, _ := p.Get(context.Background(), "core/schema-version")
+	if !ok || v.Val != 1 {
+		t.Errorf("core/schema-version defaults to %v, want 1 for configurations saved before versioning", v.Val)
+	}
+
+	// Saving, as zhi set does without BeforeDisplay, stamps the current
+	// version and leaves values in the current format alone.
+	tree := newDefaultTree(t, map[string]any{"pihole/upstream-dns": "1.1.1.1;8.8.8.8"})
+	if err := (computeTransform{p: p}).AfterSave(context.Background(), tree); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tree.Get("core/schema-version"); v.Val != schemaVersion {
+		t.Errorf("schema-version after save = %v, want %d", v.Val, schemaVersion)
+	}
+	if v, _ := tree.Get("pihole/upstream-dns"); v.Val != "1.1.1.1;8.8.8.8" || v.Metadata["config.migrated"] != nil {
+		t.Errorf("upstream-dns after save = %q %v", v.Val, v.Metadata)
+	}
+}
+
+func TestMigrateTree(t *testing.T) {
+	p := newHomeserverPlugin()
+
+	tree := newDefaultTree(t, map[string]any{"core/schema-version": 1, "pihole/upstream-dns": "1.1.1.1,8.8.8.8"})
+	records := p.migrateTree(tree)
+	if len(records) != 1 {
+		t.Fatalf("got %d records: %v", len(records), records)
+	}
+	if v, _ := tree.Get("pihole/upstream-dns"); v.Val != "1.1.1.1;8.8.8.8" {
+		t.Errorf("upstream-dns = %q", v.Val)
+	}
+	if v, _ := tree.Get("core/schema-version"); v.Val != schemaVersion {
+		t.Errorf("schema-version = %v, want %d", v.Val, schemaVersion)
+	}
+
+	// A tree saved before versioning has no version and is migrated.
+	tree = newDefaultTree(t, map[string]any{"pihole/upstream-dns": "1.1.1.1,8.8.8.8"})
+	tree.Delete("core/schema-version")
+	if records := p.migrateTree(tree); len(records) != 1 {
+		t.Errorf("unversioned tree: got %d records: %v", len(records), records)
+	}
+
+	// A tree at the current version is left alone.
+	tree = newDefaultTree(t, map[string]any{"core/schema-version": schemaVersion, "pihole/upstream-dns": "1.1.1.1,8.8.8.8"})
+	if records := p.migrateTree(tree); len(records) != 0 {
+		t.Errorf("current tree migrated: %v", records)
+	}
+}
+
+func TestMigrateTreeRenamedPath(t *testing.T) {
+	withRename(t, "plex/port", "plex/web-port")
+	p := newHomeserverPlugin()
+
+	if paths, _ := p.List(context.Background()); !slices.Contains(paths, "plex/port") {
+		t.Fatal("List does not include the old path")
+	}
+
+	tree := newDefaultTree(t, map[string]any{"core/schema-version": 1, "plex/port": 32401})
+	p.migrateTree(tree)
+	if _, ok := tree.Get("plex/port"); ok {
+		t.Error("old path still in tree")
+	}
+	if v, _ := tree.Get("plex/web-port"); v.Val != 32401 {
+		t.Errorf("plex/web-port = %v, want the saved value of plex/port", v.Val)
+	}
+
+	// A value set at the new path wins over the old one.
+	tree = newDefaultTree(t, map[string]any{"core/schema-version": 1, "plex/port": 32401, "plex/web-port": 32500})
+	p.migrateTree(tree)
+	if v, _ := tree.Get("plex/web-port"); v.Val != 32500 {
+		t.Errorf("plex/web-port = %v, want 32500", v.Val)
+	}
+}
+
+func TestValidateDeprecatedPath(t *testing.T) {
+	withRename(t, "plex/port", "plex/web-port")
+	p := newHomeserverPlugin()
+	tree := newDefaultTree(t, nil)
+	results, err := p.Validate(context.Background(), "plex/port", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Severity != config.Warning {
+		t.Errorf("expected a warning for the old path, got %v", results)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+//go:embed common-passwords.txt
+var commonPasswordsTxt string
+
+// commonPasswords is the set of passwords that are rejected as guessable
+// regardless of their length.
+var commonPasswords = parseWordList(commonPasswordsTxt)
+
+// minPasswordBits is the entropy below which a password is reported as
+// weak. 16 random letters and digits comfortably exceed it.
+const minPasswordBits = 50
+
+// parseWordList reads a list with one lowercase word per line, skipping
+// blank lines and # comments.
+func parseWordList(s string) map[string]bool {
+	words := map[string]bool{}
+	for line := range strings.Lines(s) {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			words[line] = true
+		}
+	}
+	return words
+}
+
+// leetReplacer undoes common character substitutions so "P@ssw0rd"
+// matches "password".
+var leetReplacer = strings.NewReplacer(
+	"0", "o", "1", "i", "3", "e", "4", "a", "5", "s", "7", "t", "@", "a", "$", "s", "!", "i",
+)
+
+// isCommonPassword reports whether s is, or is a trivial variation of, an
+// entry of the common password list. Variations are case changes,
+// leetspeak substitutions and digits or symbols added before or after the
+// word, as in "Nextcloud2024!".
+func isCommonPassword(s string) bool {
+	lower := strings.ToLower(s)
+	if commonPasswords[lower] {
+		return true
+	}
+	trimmed := strings.TrimFunc(lower, func(r rune) bool { return !unicode.IsLetter(r) })
+	for _, cand := range []string{trimmed, leetReplacer.Replace(lower), leetReplacer.Replace(trimmed)} {
+		if cand != "" && commonPasswords[cand] {
+			return true
+		}
+	}
+	return false
+}
+
+// passwordBits estimates the entropy of s in bits. Each character counts
+// with the smaller of the size of the character classes used (lowercase,
+// uppercase, digits, other) and the Shannon entropy of the characters
+// actually used, so long runs of repeated characters do not look strong.
+func passwordBits(s string) float64 {
+	runes := []rune(s)
+	if len(runes) == 0 {
+		return 0
+	}
+	var lower, upper, digit, other bool
+	counts := map[rune]int{}
+	for _, r := range runes {
+		counts[r]++
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if other {
+		pool += 33
+	}
+	var shannon float64
+	for _, c := range counts {
+		p := float64(c) / float64(len(runes))
+		shannon -= p * math.Log2(p)
+	}
+	return float64(len(runes)) * min(math.Log2(float64(pool)), shannon)
+}
+
+// validatePasswordStrength warns about guessable passwords. Empty values
+// are left to validateRequired. Credentials with a fixed shape, like the
+// Plex claim token, are issued by a third party and not checked.
+func validatePasswordStrength(d *ValueDef, v config.Value) []config.ValidationResult {
+	if !d.Password || d.Pattern != nil {
+		return nil
+	}
+	s, _ := v.Val.(string)
+	if s == "" {
+		return nil
+	}
+	if isCommonPassword(s) {
+		return []config.ValidationResult{{
+			Message:  "This is a commonly used password and easy to guess. Choose a random password of 16 or more characters.",
+			Severity: config.Warning,
+		}}
+	}
+	if bits := passwordBits(s); bits < minPasswordBits {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("This password is weak (about %.0f bits of entropy, at least %d recommended). Choose a random password of 16 or more characters.", bits, minPasswordBits),
+			Severity: config.Warning,
+		}}
+	}
+	return nil
+}
+
+// passwordPaths lists the paths of all password values, including those
+// declared by the values overlay.
+func (p *homeserverPlugin) passwordPaths() []string {
+	var paths []string
+	for _, path := range p.paths {
+		if p.defs[path].Password {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// validatePasswordReuse reports passwords of enabled components that
+// share their value with path. Reuse across components is Blocking, since
+// one leaked service then opens the others; reuse within a component is a
+// Warning.
+func (p *homeserverPlugin) validatePasswordReuse(path string, tree config.TreeReader) ([]config.ValidationResult, error) {
+	v, ok := tree.Get(path)
+	if !ok {
+		return nil, nil
+	}
+	s, _ := v.Val.(string)
+	if d, ok := p.defs[path]; s == "" || !ok || !d.Password {
+		return nil, nil
+	}
+	own := pathComponent(tree, path)
+	var results []config.ValidationResult
+	for _, other := range p.passwordPaths() {
+		if other == path {
+			continue
+		}
+		c := pathComponent(tree, other)
+		if c != "" && !componentEnabled(tree, c) {
+			continue
+		}
+		ov, ok := tree.Get(other)
+		if !ok {
+			continue
+		}
+		if o, _ := ov.Val.(string); o != s {
+			continue
+		}
+		if c == own {
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("The same password is used for %s. Use a separate password for each account.", other),
+				Severity: config.Warning,
+			})
+			continue
+		}
+		results = append(results, config.ValidationResult{
+			Message:  fmt.Sprintf("The same password is used for %s of the %s service. Reusing a secret across services means one leak exposes both; use a separate password.", other, c),
+			Severity: config.Blocking,
+		})
+	}
+	return results, nil
+}
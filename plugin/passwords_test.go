@@ -0,0 +1,188 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestIsCommonPassword(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"password", true},
+		{"Password", true},
+		{"P@ssw0rd", true},
+		{"Nextcloud2024!", true},
+		{"123456", true},
+		{"admin", true},
+		{"correct horse battery staple", false},
+		{"Xk9vQ2mL7pR4tW8z", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := isCommonPassword(tt.in); got != tt.want {
+				t.Errorf("isCommonPassword(%q) = %v, want %v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePasswordStrength(t *testing.T) {
+	secret, err := generateSecret(secretLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		val  string
+		warn bool
+	}{
+		{"empty", "", false},
+		{"common", "changeme", true},
+		{"short", "x7!Kq", true},
+		{"repeated", "aaaaaaaaaaaaaaaaaaaaaaaa", true},
+		{"lowercase only", "qmzhrtwkd", true},
+		{"random mixed", "Xk9vQ2mL7pR4tW8z", false},
+		{"passphrase", "correct horse battery staple", false},
+		{"generated", secret, false},
+	}
+	d := &ValueDef{Path: "mariadb/root-password", Type: "string", Password: true}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := validatePasswordStrength(d, config.Value{Val: tt.val})
+			if got := len(results) > 0; got != tt.warn {
+				t.Fatalf("warn = %v, want %v (%v)", got, tt.warn, results)
+			}
+			for _, r := range results {
+				if r.Severity != config.Warning {
+					t.Errorf("severity = %v, want Warning", r.Severity)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePasswordStrengthSkipsTokens(t *testing.T) {
+	d := &ValueDef{Path: "plex/claim-token", Type: "string", Password: true, Pattern: regexp.MustCompile(`^claim-[A-Za-z0-9_-]+$`)}
+	if results := validatePasswordStrength(d, config.Value{Val: "claim-abc"}); len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
+
+func TestValidatePasswordReuse(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		path      string
+		want      []config.Severity
+	}{
+		{
+			name: "distinct generated passwords",
+			path: "mariadb/root-password",
+		},
+		{
+			name: "reused across components",
+			overrides: map[string]any{
+				"mariadb/root-password":    "Xk9vQ2mL7pR4tW8z",
+				"nextcloud/admin-password": "Xk9vQ2mL7pR4tW8z",
+			},
+			path: "mariadb/root-password",
+			want: []config.Severity{config.Blocking},
+		},
+		{
+			name: "reused within a component",
+			overrides: map[string]any{
+				"mariadb/root-password":      "Xk9vQ2mL7pR4tW8z",
+				"mariadb/nextcloud-password": "Xk9vQ2mL7pR4tW8z",
+			},
+			path: "mariadb/nextcloud-password",
+			want: []config.Severity{config.Warning},
+		},
+		{
+			name: "empty passwords are not reuse",
+			overrides: map[string]any{
+				"nextcloud/smtp-password": "",
+				"plex/claim-token":        "",
+			},
+			path: "nextcloud/smtp-password",
+		},
+		{
+			name: "not a password",
+			overrides: map[string]any{
+				"core/domain":           "Xk9vQ2mL7pR4tW8z",
+				"mariadb/root-password": "Xk9vQ2mL7pR4tW8z",
+			},
+			path: "core/domain",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newDefaultTree(t, tt.overrides)
+			results, err := newHomeserverPlugin().validatePasswordReuse(tt.path, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != len(tt.want) {
+				t.Fatalf("got %d results, want %d: %v", len(results), len(tt.want), results)
+			}
+			for i, r := range results {
+				if r.Severity != tt.want[i] {
+					t.Errorf("result %d severity = %v, want %v", i, r.Severity, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePasswordReuseIgnoresDisabledComponents(t *testing.T) {
+	dir := writeWorkspace(t, `{"mariadb": true, "nextcloud": false}`)
+	st, err := loadComponentState(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := componentTree{
+		TreeReader: newDefaultTree(t, map[string]any{
+			"mariadb/root-password":    "Xk9vQ2mL7pR4tW8z",
+			"nextcloud/admin-password": "Xk9vQ2mL7pR4tW8z",
+		}),
+		state: st,
+	}
+	results, err := newHomeserverPlugin().validatePasswordReuse("mariadb/root-password", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results, got %v", results)
+	}
+}
+
+func TestValidatePasswordReuseOverlayPasswords(t *testing.T) {
+	dir := t.TempDir()
+	overlay := "values:\n  - path: nextcloud/smtp-relay-password\n    type: string\n    password: true\n"
+	if err := os.WriteFile(filepath.Join(dir, "values.local.yaml"), []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZHI_WORKSPACE", dir)
+	t.Setenv("ZHI_VALUES_OVERLAY", "values.local.yaml")
+	p := newHomeserverPlugin()
+	if p.loadErr != nil {
+		t.Fatal(p.loadErr)
+	}
+
+	tree := newDefaultTree(t, map[string]any{"mariadb/root-password": "Xk9vQ2mL7pR4tW8z"})
+	tree.Set("nextcloud/smtp-relay-password", &config.Value{Val: "Xk9vQ2mL7pR4tW8z"})
+	for _, path := range []string{"mariadb/root-password", "nextcloud/smtp-relay-password"} {
+		results, err := p.validatePasswordReuse(path, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || results[0].Severity != config.Blocking {
+			t.Errorf("%s: results %v, want one blocking result", path, results)
+		}
+	}
+}
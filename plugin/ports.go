@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// hostPortDef describes a host port published by a service. Ports are
+// either read from a config path or fixed by the service itself (Plex
+// runs with network_mode: host and binds its ports directly).
+type hostPortDef struct {
+	Path      string   // config path the port belongs to
+	BindPath  string   // config path of the address the port listens on; "" for all interfaces
+	Component string   // component that publishes the port
+	Protocols []string // tcp and/or udp
+	Fixed     int      // fixed port number; 0 means "read from Path"
+	Label     string   // human-readable name for fixed ports
+}
+
+// hostPortDefs lists every host port the generated stack can publish.
+// Fixed Plex ports are attributed to plex/web-port so conflicts are
+// reported on a path the user can see.
+var hostPortDefs = []hostPortDef{
+	{Path: "pihole/dns-port", BindPath: "pihole/dns-bind-address", Component: "pihole", Protocols: []string{"tcp", "udp"}},
+	{Path: "pihole/web-port", BindPath: "pihole/web-bind-address", Component: "pihole", Protocols: []string{"tcp"}},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"tcp"}},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 1900, Label: "Plex DLNA discovery"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 5353, Label: "Plex Bonjour/Avahi"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"tcp"}, Fixed: 8324, Label: "Plex Roku companion"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 32410, Label: "Plex GDM discovery"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 32412, Label: "Plex GDM discovery"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 32413, Label: "Plex GDM discovery"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"udp"}, Fixed: 32414, Label: "Plex GDM discovery"},
+	{Path: "plex/web-port", Component: "plex", Protocols: []string{"tcp"}, Fixed: 32469, Label: "Plex DLNA server"},
+	{Path: "nextcloud/web-port", BindPath: "nextcloud/web-bind-address", Component: "nextcloud", Protocols: []string{"tcp"}},
+	{Path: "nginx-proxy-manager/http-port", BindPath: "nginx-proxy-manager/http-bind-address", Component: "nginx-proxy-manager", Protocols: []string{"tcp"}},
+	{Path: "nginx-proxy-manager/https-port", BindPath: "nginx-proxy-manager/https-bind-address", Component: "nginx-proxy-manager", Protocols: []string{"tcp"}},
+	{Path: "nginx-proxy-manager/admin-port", BindPath: "nginx-proxy-manager/admin-bind-address", Component: "nginx-proxy-manager", Protocols: []string{"tcp"}},
+}
+
+// hostPort is a resolved host port binding of an enabled component.
+type hostPort struct {
+	hostPortDef
+	Port int
+	Addr string // address the port listens on; "" for all interfaces
+}
+
+// name returns a description of the binding for use in messages.
+func (p hostPort) name() string {
+	if p.Label != "" {
+		return p.Label
+	}
+	return p.Path
+}
+
+// hostPorts resolves all host ports of the components enabled in tree.
+// Ports whose value cannot be read as a number are skipped; they are
+// reported by the per-path validators instead.
+func hostPorts(tree config.TreeReader) []hostPort {
+	var ports []hostPort
+	for _, d := range hostPortDefs {
+		if !componentEnabled(tree, d.Component) {
+			continue
+		}
+		port := d.Fixed
+		if port == 0 {
+			v, ok := tree.Get(d.Path)
+			if !ok {
+				continue
+			}
+			n, ok := toInt(v.Val)
+			if !ok {
+				continue
+			}
+			port = n
+		}
+		ports = append(ports, hostPort{hostPortDef: d, Port: port, Addr: bindAddress(tree, d.BindPath)})
+	}
+	return ports
+}
+
+// validatePortConflicts reports a Blocking result when a port owned by
+// path collides with another host port of an enabled component. Every
+// path involved in a collision receives its own result.
+func validatePortConflicts(path string, tree config.TreeReader) ([]config.ValidationResult, error) {
+	ports := hostPorts(tree)
+	var results []config.ValidationResult
+	for i, own := range ports {
+		if own.Path != path {
+			continue
+		}
+		for j, other := range ports {
+			if i == j || own.Port != other.Port || !addrsOverlap(own.Addr, other.Addr) {
+				continue
+			}
+			shared := sharedProtocols(own.Protocols, other.Protocols)
+			if len(shared) == 0 {
+				continue
+			}
+			results = append(results, config.ValidationResult{
+				Message: fmt.Sprintf("Host port %d/%s of %s is also used by %s. Choose a different port for one of them.",
+					own.Port, strings.Join(shared, "+"), own.name(), other.name()),
+				Severity: config.Blocking,
+			})
+		}
+	}
+	return results, nil
+}
+
+// bindAddress returns the host address at path, or core/bind-address if
+// it is empty, like the Compose template. "" means all interfaces.
+func bindAddress(tree config.TreeReader, path string) string {
+	if path == "" {
+		return ""
+	}
+	if addr := treeString(tree, path); addr != "" {
+		return addr
+	}
+	return treeString(tree, "core/bind-address")
+}
+
+// addrsOverlap reports whether ports on the addresses a and b collide: an
+// empty or unspecified address listens on every address.
+func addrsOverlap(a, b string) bool {
+	if a == b {
+		return true
+	}
+	for _, s := range []string{a, b} {
+		if addr, err := netip.ParseAddr(s); s == "" || err == nil && addr.IsUnspecified() {
+			return true
+		}
+	}
+	x, errX := netip.ParseAddr(a)
+	y, errY := netip.ParseAddr(b)
+	return errX == nil && errY == nil && x.Unmap() == y.Unmap()
+}
+
+// sharedProtocols returns the protocols present in both a and b.
+func sharedProtocols(a, b []string) []string {
+	var shared []string
+	for _, p := range a {
+		if slices.Contains(b, p) {
+			shared = append(shared, p)
+		}
+	}
+	return shared
+}
+
+// exposedPorts lists the distinct host ports of the enabled components as
+// "<port>/<protocol>", ordered by port and protocol.
+func exposedPorts(tree config.TreeReader) []string {
+	type key struct {
+		port  int
+		proto string
+	}
+	seen := map[key]bool{}
+	var keys []key
+	for _, p := range hostPorts(tree) {
+		for _, proto := range p.Protocols {
+			k := key{p.Port, proto}
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	slices.SortFunc(keys, func(a, b key) int {
+		if a.port != b.port {
+			return a.port - b.port
+		}
+		return strings.Compare(a.proto, b.proto)
+	})
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = fmt.Sprintf("%d/%s", k.port, k.proto)
+	}
+	return out
+}
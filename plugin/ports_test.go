@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// newDefaultTree builds a tree from the plugin's defaults and applies the
+// given overrides on top.
+func newDefaultTree(t *testing.T, overrides map[string]any) *config.Tree {
+	t.Helper()
+	p := newHomeserverPlugin()
+	tree := config.NewTree()
+	paths, _ := p.List(context.Background())
+	for _, path := range paths {
+		v, ok, _ := p.Get(context.Background(), path)
+		if ok {
+			tree.Set(path, &v)
+		}
+	}
+	for path, val := range overrides {
+		if err := tree.Set(path, &config.Value{Val: val}); err != nil {
+			t.Fatalf("Set(%q): %v", path, err)
+		}
+	}
+	return tree
+}
+
+func TestValidatePortConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		path      string
+		conflicts int
+	}{
+		{"defaults do not conflict", nil, "nextcloud/web-port", 0},
+		{"same web port blocks nextcloud", map[string]any{"pihole/web-port": 8080}, "nextcloud/web-port", 1},
+		{"same web port blocks pihole", map[string]any{"pihole/web-port": 8080}, "pihole/web-port", 1},
+		{"npm admin port vs pihole web", map[string]any{"nginx-proxy-manager/admin-port": 8053}, "nginx-proxy-manager/admin-port", 1},
+		{"dns port vs plex bonjour", map[string]any{"pihole/dns-port": 5353}, "pihole/dns-port", 1},
+		{"dns port vs plex bonjour on plex path", map[string]any{"pihole/dns-port": 5353}, "plex/web-port", 1},
+		{"different protocols do not conflict", map[string]any{"nextcloud/web-port": 1900}, "nextcloud/web-port", 0},
+		{"different bind addresses do not conflict", map[string]any{"pihole/web-port": 8080, "pihole/web-bind-address": "127.0.0.1", "nextcloud/web-bind-address": "192.168.1.10"}, "nextcloud/web-port", 0},
+		{"same bind address blocks", map[string]any{"pihole/web-port": 8080, "pihole/web-bind-address": "192.168.1.10", "nextcloud/web-bind-address": "192.168.1.10"}, "nextcloud/web-port", 1},
+		{"all interfaces overlap a bind address", map[string]any{"pihole/web-port": 8080, "pihole/web-bind-address": "127.0.0.1"}, "nextcloud/web-port", 1},
+		{"core bind address applies to every port", map[string]any{"pihole/web-port": 8080, "core/bind-address": "192.168.1.10", "pihole/web-bind-address": "127.0.0.1"}, "nextcloud/web-port", 0},
+		{"plex host network overlaps a bind address", map[string]any{"pihole/dns-port": 5353, "pihole/dns-bind-address": "192.168.1.10"}, "pihole/dns-port", 1},
+		{"unrelated path has no result", map[string]any{"pihole/web-port": 8080}, "core/domain", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newDefaultTree(t, tt.overrides)
+			results, err := validatePortConflicts(tt.path, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) != tt.conflicts {
+				t.Fatalf("got %d results, want %d: %v", len(results), tt.conflicts, results)
+			}
+			for _, r := range results {
+				if r.Severity != config.Blocking {
+					t.Errorf("severity = %v, want Blocking", r.Severity)
+				}
+			}
+		})
+	}
+}
+
+func TestValidatePortConflictsSkipsAbsentComponents(t *testing.T) {
+	tree := newDefaultTree(t, map[string]any{"pihole/web-port": 8080})
+	for _, path := range tree.List() {
+		if strings.HasPrefix(path, "nextcloud/") {
+			tree.Delete(path)
+		}
+	}
+	results, err := validatePortConflicts("pihole/web-port", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no conflicts without nextcloud, got %v", results)
+	}
+}
+
+func TestHostPortDefsReferenceKnownPaths(t *testing.T) {
+	known := make(map[string]bool, len(valueDefs))
+	for _, d := range valueDefs {
+		known[d.Path] = true
+	}
+	for _, d := range hostPortDefs {
+		if !known[d.Path] {
+			t.Errorf("host port registered for unknown path: %s", d.Path)
+		}
+		if d.BindPath != "" && !known[d.BindPath] {
+			t.Errorf("host port %s has unknown bind address path: %s", d.Path, d.BindPath)
+		}
+	}
+}
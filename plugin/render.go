@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	sprig "github.com/Masterminds/sprig/v3"
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// exportTemplate mirrors an entry of export.templates in the workspace's
+// zhi.yaml.
+type exportTemplate struct {
+	Name     string `yaml:"name"`
+	Template string `yaml:"template"`
+	Output   string `yaml:"output"`
+}
+
+// loadExportTemplates reads the export templates of the workspace in dir.
+// Template and output paths are resolved against dir.
+func loadExportTemplates(dir string) ([]exportTemplate, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "zhi.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var ws struct {
+		Export struct {
+			Templates []exportTemplate `yaml:"templates"`
+		} `yaml:"export"`
+	}
+	if err := yaml.Unmarshal(data, &ws); err != nil {
+		return nil, err
+	}
+	tmpls := ws.Export.Templates
+	for i := range tmpls {
+		tmpls[i].Template = filepath.Join(dir, tmpls[i].Template)
+		tmpls[i].Output = filepath.Join(dir, tmpls[i].Output)
+	}
+	return tmpls, nil
+}
+
+// withOnly returns a copy of s in which exactly the mandatory components,
+// the named ones and, like "zhi component enable", their dependencies are
+// enabled.
+func (s *componentState) withOnly(names []string) (*componentState, error) {
+	byName := make(map[string]componentDef, len(s.defs))
+	for _, d := range s.defs {
+		byName[d.Name] = d
+	}
+	out := &componentState{defs: s.defs, enabled: make(map[string]bool, len(s.defs))}
+	var enable func(name string) error
+	enable = func(name string) error {
+		d, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("unknown component %q", name)
+		}
+		if out.enabled[name] {
+			return nil
+		}
+		out.enabled[name] = true
+		for _, dep := range d.Dependencies {
+			if err := enable(dep); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, d := range s.defs {
+		if d.Mandatory {
+			out.enabled[d.Name] = true
+		}
+	}
+	for _, name := range names {
+		if err := enable(name); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// filterTree returns the values of tree that do not belong to a disabled
+// component, like zhi's component filter before an export.
+func (s *componentState) filterTree(tree config.TreeReader) *config.Tree {
+	out := config.NewTree()
+	for _, path := range tree.List() {
+		if c, owned := s.componentOf(path); owned && !s.Enabled(c) {
+			continue
+		}
+		if v, ok := tree.Get(path); ok {
+			out.Set(path, &v)
+		}
+	}
+	return out
+}
+
+// templateData is the data export templates are executed with. It mirrors
+// the methods zhi's TreeData offers, so templates render the same way as
+// in "zhi export".
+type templateData struct {
+	tree  config.TreeReader
+	state *componentState
+}
+
+// newTemplateData returns the template data for tree with the values of
+// disabled components removed.
+func newTemplateData(tree config.TreeReader, st *componentState) *templateData {
+	return &templateData{tree: st.filterTree(tree), state: st}
+}
+
+// Get returns the value at path as a string, or "" if it does not exist.
+func (td *templateData) Get(path string) string {
+	return td.GetOr(path, "")
+}
+
+// GetOr returns the value at path as a string, or defaultVal if it does
+// not exist.
+func (td *templateData) GetOr(path, defaultVal string) string {
+	v, ok := td.tree.Get(path)
+	if !ok {
+		return defaultVal
+	}
+	return fmt.Sprintf("%v", v.Val)
+}
+
+// Has reports whether path exists in the filtered tree.
+func (td *templateData) Has(path string) bool {
+	_, ok := td.tree.Get(path)
+	return ok
+}
+
+// All returns all values keyed by path.
+func (td *templateData) All() map[string]any {
+	out := map[string]any{}
+	for _, path := range td.tree.List() {
+		if v, ok := td.tree.Get(path); ok {
+			out[path] = v.Val
+		}
+	}
+	return out
+}
+
+// Prefix returns the values under prefix, keyed by the rest of their path.
+func (td *templateData) Prefix(prefix string) map[string]any {
+	p := strings.TrimSuffix(prefix, "/") + "/"
+	out := map[string]any{}
+	for path, val := range td.All() {
+		if key, ok := strings.CutPrefix(path, p); ok {
+			out[key] = val
+		} else if path == prefix {
+			out[path] = val
+		}
+	}
+	return out
+}
+
+// Nested returns the values under prefix as nested maps split on "/". An
+// empty prefix nests all values.
+func (td *templateData) Nested(prefix string) map[string]any {
+	out := map[string]any{}
+	p := ""
+	if prefix != "" {
+		p = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	for path, val := range td.All() {
+		key, ok := strings.CutPrefix(path, p)
+		if !ok || key == "" {
+			continue
+		}
+		m := out
+		parts := strings.Split(key, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := m[part].(map[string]any)
+			if !ok {
+				child = map[string]any{}
+				m[part] = child
+			}
+			m = child
+		}
+		m[parts[len(parts)-1]] = val
+	}
+	return out
+}
+
+// Meta returns the metadata label key of path as a string, or "".
+func (td *templateData) Meta(path, key string) string {
+	v, ok := td.tree.Get(path)
+	if !ok {
+		return ""
+	}
+	mv, ok := v.Metadata[key]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("%v", mv)
+}
+
+// ComponentEnabled reports whether the named component is enabled.
+func (td *templateData) ComponentEnabled(name string) bool {
+	return td.state.Enabled(name)
+}
+
+// EnabledComponents returns the sorted names of the enabled components.
+func (td *templateData) EnabledComponents() []string {
+	return td.components(true)
+}
+
+// DisabledComponents returns the sorted names of the disabled components.
+func (td *templateData) DisabledComponents() []string {
+	return td.components(false)
+}
+
+func (td *templateData) components(enabled bool) []string {
+	var names []string
+	for _, d := range td.state.defs {
+		if td.state.Enabled(d.Name) == enabled {
+			names = append(names, d.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// ComponentPaths returns the path prefixes of the named component.
+func (td *templateData) ComponentPaths(name string) []string {
+	for _, d := range td.state.defs {
+		if d.Name == name {
+			return slices.Clone(d.Paths)
+		}
+	}
+	return nil
+}
+
+// templateFuncs returns the functions zhi offers to export templates:
+// sprig plus toYAML, toTOML, toDotenv and shellQuote. fileACL and
+// fileMode only affect how zhi writes the file and render as "".
+func templateFuncs() template.FuncMap {
+	fm := sprig.TxtFuncMap()
+	fm["toYAML"] = func(v any) (string, error) {
+		b, err := yaml.Marshal(v)
+		return strings.TrimSpace(string(b)), err
+	}
+	fm["toTOML"] = func(v any) (string, error) {
+		b, err := toml.Marshal(v)
+		return strings.TrimSpace(string(b)), err
+	}
+	fm["toDotenv"] = func(v any) (string, error) {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return "", fmt.Errorf("toDotenv requires a map[string]any, got %T", v)
+		}
+		var sb strings.Builder
+		for _, k := range slices.Sorted(maps.Keys(m)) {
+			fmt.Fprintf(&sb, "%s=%v\n", strings.ToUpper(strings.ReplaceAll(k, "/", "_")), m[k])
+		}
+		return strings.TrimSpace(sb.String()), nil
+	}
+	fm["shellQuote"] = escapeShell
+	fm["fileACL"] = func(string) string { return "" }
+	fm["fileMode"] = func(int) string { return "" }
+	return fm
+}
+
+// renderTemplateFile executes the template in file with data.
+func renderTemplateFile(file string, data *templateData) (string, error) {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("reading template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(file)).Funcs(templateFuncs()).Parse(string(src))
+	if err != nil {
+		return "", fmt.Errorf("parsing template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("executing template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// renderedFile is the output of an export template.
+type renderedFile struct {
+	exportTemplate
+	Content string
+}
+
+// renderTemplates renders tmpls against tree with the component state st.
+// It renders every template and returns the errors of all that failed.
+func renderTemplates(tmpls []exportTemplate, tree config.TreeReader, st *componentState) ([]renderedFile, error) {
+	data := newTemplateData(tree, st)
+	var out []renderedFile
+	var errs []error
+	for _, t := range tmpls {
+		content, err := renderTemplateFile(t.Template, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", t.Name, err))
+			continue
+		}
+		out = append(out, renderedFile{exportTemplate: t, Content: content})
+	}
+	return out, errors.Join(errs...)
+}
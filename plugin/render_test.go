@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// testWorkspace is the workspace shipped with the plugin.
+const testWorkspace = "../workspace"
+
+func TestWithOnly(t *testing.T) {
+	st, err := loadComponentState(testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	only, err := st.withOnly([]string{"nextcloud"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]bool{
+		"core":                true,
+		"nextcloud":           true,
+		"mariadb":             true,
+		"redis":               true,
+		"pihole":              false,
+		"plex":                false,
+		"nginx-proxy-manager": false,
+	} {
+		if got := only.Enabled(name); got != want {
+			t.Errorf("Enabled(%q) = %v, want %v", name, got, want)
+		}
+	}
+	if _, err := st.withOnly([]string{"jellyfin"}); err == nil {
+		t.Error("expected an error for an unknown component")
+	}
+}
+
+func TestRenderTemplates(t *testing.T) {
+	st, err := loadComponentState(testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err = st.withOnly([]string{"pihole"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpls, err := loadExportTemplates(testWorkspace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newHomeserverPlugin()
+	p.components = st
+	files, err := renderTemplates(tmpls, p.currentTree(), st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(tmpls) {
+		t.Fatalf("rendered %d files, want %d", len(files), len(tmpls))
+	}
+	compose := files[0].Content
+	if !strings.Contains(compose, "pihole/pihole:") {
+		t.Errorf("compose file lacks the pihole service:\n%s", compose)
+	}
+	if strings.Contains(compose, "nextcloud") {
+		t.Errorf("compose file contains the disabled nextcloud service:\n%s", compose)
+	}
+	for _, f := range files {
+		if strings.Contains(f.Content, "<no value>") {
+			t.Errorf("%s contains <no value>", f.Name)
+		}
+	}
+}
+
+func TestCLIRender(t *testing.T) {
+	t.Run("stdout", func(t *testing.T) {
+		code, out, stderr := runCLITest(t, "render", "-workspace", testWorkspace, "-components", "plex", "apply-script")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		if strings.Contains(out, "# ==>") {
+			t.Error("a single template should be printed without a header")
+		}
+		// Escaped docker placeholders must survive rendering.
+		if !strings.Contains(out, `ps --format "table {{.Name}}`) {
+			t.Errorf("apply script lacks the docker ps format:\n%s", out)
+		}
+	})
+
+	t.Run("out dir", func(t *testing.T) {
+		dir := t.TempDir()
+		// A file from an earlier render is restricted too.
+		if err := os.WriteFile(filepath.Join(dir, "apply.sh"), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, _, stderr := runCLITest(t, "render", "-workspace", testWorkspace, "-out", dir)
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		for _, name := range []string{"docker-compose.yml", "apply.sh", "backup.sh"} {
+			fi, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if mode := fi.Mode().Perm(); mode != 0o600 {
+				t.Errorf("%s has mode %o, want 600", name, mode)
+			}
+		}
+	})
+
+	t.Run("values", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		if err := os.WriteFile(file, []byte("pihole:\n  web-port: 8054\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, out, stderr := runCLITest(t, "render", "-workspace", testWorkspace, "-components", "pihole", "-values", file, "docker-compose")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		if !strings.Contains(out, "8054:80/tcp") {
+			t.Errorf("compose file lacks the overridden web port:\n%s", out)
+		}
+	})
+
+	t.Run("bind addresses", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "values.yaml")
+		values := "core:\n  bind-address: 192.168.1.10\npihole:\n  web-bind-address: 127.0.0.1\n"
+		if err := os.WriteFile(file, []byte(values), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, out, stderr := runCLITest(t, "render", "-workspace", testWorkspace, "-components", "pihole", "-values", file, "docker-compose")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		for _, want := range []string{`"192.168.1.10:53:53/udp"`, `"127.0.0.1:8053:80/tcp"`} {
+			if !strings.Contains(out, want) {
+				t.Errorf("compose file lacks %s:\n%s", want, out)
+			}
+		}
+	})
+
+	t.Run("escaped values", func(t *testing.T) {
+		// Compose turns $$ back into $ after parsing the YAML.
+		want := "mixed'\"$HOME`\\ all"
+		file := filepath.Join(t.TempDir(), "values.json")
+		data, _ := json.Marshal(map[string]any{"pihole/admin-password": want})
+		if err := os.WriteFile(file, data, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		code, out, stderr := runCLITest(t, "render", "-workspace", testWorkspace, "-components", "pihole", "-values", file, "docker-compose")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		var doc struct {
+			Services map[string]struct {
+				Environment map[string]string `yaml:"environment"`
+			} `yaml:"services"`
+		}
+		if err := yaml.Unmarshal([]byte(out), &doc); err != nil {
+			t.Fatal(err)
+		}
+		got := doc.Services["pihole"].Environment["FTLCONF_webserver_api_password"]
+		if got = strings.ReplaceAll(got, "$$", "$"); got != want {
+			t.Errorf("compose reads %q, want %q", got, want)
+		}
+	})
+
+	t.Run("workspace overlay", func(t *testing.T) {
+		t.Setenv("ZHI_VALUES_OVERLAY", "values.local.yaml")
+		var dirs []string
+		for _, port := range []string{"8054", "8055"} {
+			dir := writeTemplateWorkspace(t, `{"pihole": true}`)
+			overlay := "values:\n  - path: pihole/web-port\n    default: " + port + "\n"
+			if err := os.WriteFile(filepath.Join(dir, "values.local.yaml"), []byte(overlay), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			dirs = append(dirs, dir)
+		}
+		t.Setenv("ZHI_WORKSPACE", dirs[0])
+		code, out, stderr := runCLITest(t, "render", "-workspace", dirs[1], "docker-compose")
+		if code != 0 {
+			t.Fatalf("exit code %d: %s", code, stderr)
+		}
+		if !strings.Contains(out, "8055:80/tcp") {
+			t.Errorf("compose file lacks the web port from the chosen workspace's overlay:\n%s", out)
+		}
+	})
+
+	errTests := []struct {
+		name string
+		args []string
+		code int
+	}{
+		{"unknown component", []string{"-components", "jellyfin"}, 2},
+		{"unknown template", []string{"nope"}, 2},
+		{"missing workspace", []string{"-workspace", "does-not-exist"}, 1},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"render", "-workspace", testWorkspace}, tt.args...)
+			if code, _, _ := runCLITest(t, args...); code != tt.code {
+				t.Errorf("exit code %d, want %d", code, tt.code)
+			}
+		})
+	}
+}
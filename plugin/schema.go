@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"slices"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// jsonSchemaDraft is the JSON Schema dialect of the exported schema.
+const jsonSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// valuesSchema is the JSON Schema of a values file: a flat object that
+// maps config paths to their values.
+type valuesSchema struct {
+	Schema               string                 `json:"$schema"`
+	Title                string                 `json:"title"`
+	Description          string                 `json:"description"`
+	Type                 string                 `json:"type"`
+	Properties           map[string]valueSchema `json:"properties"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties bool                   `json:"additionalProperties"`
+}
+
+// valueSchema is the JSON Schema of a single value.
+type valueSchema struct {
+	Type        string   `json:"type"`
+	Title       string   `json:"title,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Default     any      `json:"default"`
+	Enum        []string `json:"enum,omitempty"`
+	Minimum     *int     `json:"minimum,omitempty"`
+	Maximum     *int     `json:"maximum,omitempty"`
+	MinLength   *int     `json:"minLength,omitempty"`
+	Pattern     string   `json:"pattern,omitempty"`
+	Format      string   `json:"format,omitempty"`
+	Examples    []string `json:"examples,omitempty"`
+	ReadOnly    bool     `json:"readOnly,omitempty"`
+	WriteOnly   bool     `json:"writeOnly,omitempty"`
+}
+
+// schemaTypes maps ValueDef types to JSON Schema types. Sizes are
+// written as strings such as 256M.
+var schemaTypes = map[string]string{
+	"string": "string",
+	"size":   "string",
+	"int":    "integer",
+	"bool":   "boolean",
+}
+
+// schemaFormats maps the formats of formats.go to the JSON Schema formats
+// that check the same thing. Formats without an equivalent are described
+// by a pattern where possible.
+var schemaFormats = map[string]string{
+	"domain":   "hostname",
+	"hostname": "hostname",
+	"email":    "email",
+	"url":      "uri",
+	"ipv4":     "ipv4",
+	"ipv6":     "ipv6",
+}
+
+// inlineFlagRe matches Go regexp flag groups such as (?i), which the
+// ECMA-262 dialect of JSON Schema does not support.
+var inlineFlagRe = regexp.MustCompile(`\(\?[a-zA-Z]`)
+
+// jsonSchema returns the JSON Schema of the plugin's values. Defaults are
+// the static defaults: derived defaults are resolved without any other
+// value set, and generated secrets are left out. Only values that are
+// required whatever the enabled components are listed as required:
+// values of optional components and generated passwords may be missing
+// from a values file.
+func (p *homeserverPlugin) jsonSchema() valuesSchema {
+	s := valuesSchema{
+		Schema:               jsonSchemaDraft,
+		Title:                "Home server configuration values",
+		Description:          "Values of the zhi home server workspace, keyed by config path.",
+		Type:                 "object",
+		Properties:           make(map[string]valueSchema, len(p.paths)),
+		AdditionalProperties: false,
+	}
+	st, _ := p.componentState()
+	for _, path := range p.paths {
+		d := p.defs[path]
+		s.Properties[path] = d.jsonSchema()
+		if d.Required && !d.AutoGenerate && len(d.RequiredWith) == 0 && st.mandatory(path) {
+			s.Required = append(s.Required, path)
+		}
+	}
+	slices.Sort(s.Required)
+	return s
+}
+
+// jsonSchema returns the JSON Schema of the value d defines.
+func (d *ValueDef) jsonSchema() valueSchema {
+	vs := valueSchema{
+		Type:        schemaTypes[d.Type],
+		Title:       d.DisplayName,
+		Description: d.Description,
+		Default:     d.defaultValue(config.NewTree()),
+		Enum:        d.SelectFrom,
+		Minimum:     d.Min,
+		Maximum:     d.Max,
+		ReadOnly:    d.ReadOnly || d.Compute != nil,
+		WriteOnly:   d.Password,
+	}
+	if d.Placeholder != "" {
+		vs.Examples = []string{d.Placeholder}
+	}
+	if d.Required {
+		vs.MinLength = new(1)
+	}
+	// List values are checked per element, which a single pattern or
+	// format on the whole string cannot express.
+	if d.Separator != "" {
+		return vs
+	}
+	vs.Format = schemaFormats[d.Format]
+	var pattern string
+	if d.Pattern != nil {
+		pattern = d.Pattern.String()
+	} else if f, ok := formats[d.Format]; ok && vs.Format == "" {
+		pattern = f.Pattern
+	}
+	if !inlineFlagRe.MatchString(pattern) {
+		vs.Pattern = pattern
+	}
+	return vs
+}
+
+// writeJSONSchema writes the JSON Schema of the plugin's values to w.
+func (p *homeserverPlugin) writeJSONSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.jsonSchema())
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"testing"
+)
+
+func TestJSONSchema(t *testing.T) {
+	p := newHomeserverPlugin()
+	s := p.jsonSchema()
+
+	if s.Schema != jsonSchemaDraft || s.Type != "object" || s.AdditionalProperties {
+		t.Errorf("unexpected root schema: %+v", s)
+	}
+	if len(s.Properties) != len(valueDefs) {
+		t.Errorf("got %d properties, want %d", len(s.Properties), len(valueDefs))
+	}
+	// core/domain is only required with some components, and empty
+	// passwords are generated.
+	for _, path := range []string{"core/domain", "pihole/admin-password", "mariadb/root-password"} {
+		if slices.Contains(s.Required, path) {
+			t.Errorf("required = %v, want it without %s", s.Required, path)
+		}
+	}
+
+	tests := []struct {
+		path  string
+		check func(valueSchema) bool
+	}{
+		{"pihole/web-port", func(v valueSchema) bool {
+			return v.Type == "integer" && *v.Minimum == 1 && *v.Maximum == 65535 && v.Default == 8053
+		}},
+		{"pihole/dnssec", func(v valueSchema) bool { return v.Type == "boolean" && v.Default == true }},
+		{"redis/maxmemory-policy", func(v valueSchema) bool { return slices.Contains(v.Enum, "allkeys-lru") }},
+		{"core/timezone", func(v valueSchema) bool { return slices.Contains(v.Enum, "Europe/Berlin") }},
+		{"mariadb/root-password", func(v valueSchema) bool { return v.WriteOnly && v.Default == "" && *v.MinLength == 1 }},
+		{"plex/url", func(v valueSchema) bool { return v.ReadOnly }},
+		{"core/domain", func(v valueSchema) bool { return v.Format == "hostname" && v.Pattern == "" }},
+		{"nginx-proxy-manager/letsencrypt-email", func(v valueSchema) bool { return v.Format == "email" && v.Default == "" }},
+		{"pihole/upstream-dns", func(v valueSchema) bool { return v.Format == "" && v.Pattern == "" }},
+		{"plex/image-tag", func(v valueSchema) bool { return v.Pattern == `^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$` }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			v, ok := s.Properties[tt.path]
+			if !ok {
+				t.Fatal("missing property")
+			}
+			if !tt.check(v) {
+				t.Errorf("unexpected schema: %+v", v)
+			}
+		})
+	}
+}
+
+func TestJSONSchemaRequired(t *testing.T) {
+	dir := writeWorkspace(t, `{}`)
+	overlay := `
+values:
+  - {path: core/admin-email, type: string, required: true}
+  - {path: plex/library-name, type: string, required: true}
+  - {path: core/vpn-key, type: string, password: true, autoGenerate: true, required: true}
+`
+	if err := os.WriteFile(filepath.Join(dir, "values.local.yaml"), []byte(overlay), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ZHI_WORKSPACE", dir)
+	t.Setenv("ZHI_VALUES_OVERLAY", "values.local.yaml")
+	p := newHomeserverPlugin()
+	if p.loadErr != nil {
+		t.Fatal(p.loadErr)
+	}
+	// Only the value of the mandatory core component that is not
+	// generated is required in every configuration.
+	if got := p.jsonSchema().Required; !slices.Equal(got, []string{"core/admin-email"}) {
+		t.Errorf("required = %v, want [core/admin-email]", got)
+	}
+}
+
+func TestJSONSchemaPatternsAreECMACompatible(t *testing.T) {
+	for path, v := range newHomeserverPlugin().jsonSchema().Properties {
+		if v.Pattern == "" {
+			continue
+		}
+		if inlineFlagRe.MatchString(v.Pattern) {
+			t.Errorf("%s: pattern %s uses Go flag syntax", path, v.Pattern)
+		}
+		if _, err := regexp.Compile(v.Pattern); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+}
+
+func TestCLISchema(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	if code := runCLI([]string{"schema"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code %d: %s", code, stderr.String())
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc["$schema"] != jsonSchemaDraft {
+		t.Errorf("$schema = %v", doc["$schema"])
+	}
+
+	stdout.Reset()
+	if code := runCLI([]string{"frobnicate"}, &stdout, &stderr); code != 2 {
+		t.Errorf("unknown command exit code = %d, want 2", code)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+)
+
+// secretAlphabet contains only characters that need no quoting or
+// escaping in shell scripts, YAML, env files and Compose interpolation.
+const secretAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+
+// secretLength gives generated secrets ~190 bits of entropy.
+const secretLength = 32
+
+// generateSecret returns a random string of n characters from
+// secretAlphabet.
+func generateSecret(n int) (string, error) {
+	return secretFrom(rand.Reader, n)
+}
+
+// secretFrom returns a string of n characters from secretAlphabet, read
+// from r.
+func secretFrom(r io.Reader, n int) (string, error) {
+	// Reject bytes >= 248 (the largest multiple of 62 below 256) so every
+	// character is equally likely.
+	const limit = 256 - 256%len(secretAlphabet)
+	out := make([]byte, 0, n)
+	buf := make([]byte, n)
+	for len(out) < n {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(out) < n {
+				out = append(out, secretAlphabet[int(b)%len(secretAlphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+// secretKeyFile holds the random key the secrets of a workspace are
+// derived from. It is the only file the plugin writes for generated
+// secrets; the secrets themselves are never written.
+func secretKeyFile(dir string) string {
+	return filepath.Join(dir, ".zhi", "homeserver-secret-key")
+}
+
+// secretKeySize is the size of the key in secretKeyFile in bytes.
+const secretKeySize = 32
+
+// loadSecretKey returns the secret key of the workspace in dir, creating
+// it with user-only permissions on first use. Outside an initialised
+// workspace, i.e. without a .zhi directory, it returns a random key that
+// is not saved, so the secrets change with every run.
+func loadSecretKey(dir string) ([]byte, error) {
+	file := secretKeyFile(dir)
+	if key, err := os.ReadFile(file); err == nil && len(key) == secretKeySize {
+		return key, nil
+	}
+	key := make([]byte, secretKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if fi, err := os.Stat(filepath.Dir(file)); err != nil || !fi.IsDir() {
+		return key, nil
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if errors.Is(err, fs.ErrExist) {
+		// Another plugin process created it first; use its key.
+		return os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return key, f.Close()
+}
+
+// keyStream is an endless stream of bytes derived from a key and a
+// label: HMAC-SHA256(key, label || counter) for counter 0, 1, ...
+type keyStream struct {
+	mac     hash.Hash
+	label   string
+	counter uint64
+	buf     []byte
+}
+
+func (s *keyStream) Read(p []byte) (int, error) {
+	for len(s.buf) < len(p) {
+		s.mac.Reset()
+		s.mac.Write([]byte(s.label))
+		s.mac.Write(binary.BigEndian.AppendUint64(nil, s.counter))
+		s.buf = s.mac.Sum(s.buf)
+		s.counter++
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// deriveSecret returns the secret of n characters for path, derived from
+// key. The same key and path always give the same secret.
+func deriveSecret(key []byte, path string, n int) (string, error) {
+	return secretFrom(&keyStream{mac: hmac.New(sha256.New, key), label: path}, n)
+}
+
+// generateSecrets fills the empty defaults of AutoGenerate values with
+// secrets derived from the workspace's secret key (see loadSecretKey), so
+// every run of the plugin, and every zhi export and apply, renders the
+// same passwords until the user saves their own. It runs once, on the
+// first Get or Set, so commands that never read values create no key.
+func (p *homeserverPlugin) generateSecrets() {
+	key, err := loadSecretKey(p.workspaceDir)
+	if err != nil {
+		return // leave the values empty; validateRequired reports them
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, d := range p.defs {
+		if !d.AutoGenerate || d.Default != "" {
+			continue
+		}
+		s, err := deriveSecret(key, d.Path, secretLength)
+		if err != nil {
+			continue
+		}
+		p.values[d.Path].Val = s
+		p.generated[d.Path] = s
+	}
+}
+
+// markGenerated returns md with the config.generated flag set if val is
+// the secret generated for path, and without it otherwise. zhi replaces
+// only the value when it merges a saved one, so the flag is decided from
+// the value rather than kept in the metadata. The caller must hold p.mu.
+func (p *homeserverPlugin) markGenerated(path string, val any, md map[string]any) map[string]any {
+	secret, ok := p.generated[path]
+	generated := ok && val == secret
+	if _, marked := md["config.generated"]; marked == generated {
+		return md
+	}
+	md = maps.Clone(md)
+	if md == nil {
+		md = map[string]any{}
+	}
+	if generated {
+		md["config.generated"] = true
+	} else {
+		delete(md, "config.generated")
+	}
+	return md
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestGenerateSecret(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		s, err := generateSecret(secretLength)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != secretLength {
+			t.Fatalf("len(%q) = %d, want %d", s, len(s), secretLength)
+		}
+		if strings.Trim(s, secretAlphabet) != "" {
+			t.Fatalf("%q contains characters outside the alphabet", s)
+		}
+		if seen[s] {
+			t.Fatalf("duplicate secret %q", s)
+		}
+		seen[s] = true
+	}
+}
+
+func TestPluginGeneratesSecrets(t *testing.T) {
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+	for _, d := range valueDefs {
+		v, found, err := p.Get(ctx, d.Path)
+		if err != nil || !found {
+			t.Fatalf("Get(%s) found=%v err=%v", d.Path, found, err)
+		}
+		generated, _ := v.Metadata["config.generated"].(bool)
+		if generated != d.AutoGenerate {
+			t.Errorf("%s: config.generated = %v, want %v", d.Path, generated, d.AutoGenerate)
+		}
+		if !d.AutoGenerate {
+			continue
+		}
+		if s, _ := v.Val.(string); len(s) != secretLength {
+			t.Errorf("%s: generated %q", d.Path, v.Val)
+		}
+		if res, _ := validateRequired(v, nil); len(res) != 0 {
+			t.Errorf("%s: validateRequired = %v", d.Path, res)
+		}
+	}
+
+	a, _, _ := p.Get(ctx, "mariadb/root-password")
+	b, _, _ := p.Get(ctx, "mariadb/nextcloud-password")
+	if a.Val == b.Val {
+		t.Error("passwords share the same generated secret")
+	}
+}
+
+func TestPluginSetClearsGeneratedFlag(t *testing.T) {
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+	path := "pihole/admin-password"
+	v, _, _ := p.Get(ctx, path)
+
+	// Writing back the generated value keeps the flag.
+	if err := p.Set(ctx, path, v); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _ := p.Get(ctx, path)
+	if got.Metadata["config.generated"] != true {
+		t.Error("flag lost when the generated value was stored unchanged")
+	}
+
+	// A user-chosen password clears it, even with the old metadata echoed.
+	if err := p.Set(ctx, path, config.Value{Val: "my own password", Metadata: got.Metadata}); err != nil {
+		t.Fatal(err)
+	}
+	got, _, _ = p.Get(ctx, path)
+	if _, ok := got.Metadata["config.generated"]; ok {
+		t.Error("config.generated still set after user-chosen password")
+	}
+	if v.Metadata["config.generated"] != true {
+		t.Error("Set modified the caller's metadata")
+	}
+}
+
+func TestDeriveSecret(t *testing.T) {
+	key := []byte(strings.Repeat("k", secretKeySize))
+	a, err := deriveSecret(key, "mariadb/root-password", secretLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != secretLength || strings.Trim(a, secretAlphabet) != "" {
+		t.Fatalf("derived %q", a)
+	}
+	if again, _ := deriveSecret(key, "mariadb/root-password", secretLength); again != a {
+		t.Errorf("same key and path gave %q and %q", a, again)
+	}
+	if other, _ := deriveSecret(key, "pihole/admin-password", secretLength); other == a {
+		t.Error("different paths share a secret")
+	}
+	if other, _ := deriveSecret([]byte(strings.Repeat("x", secretKeySize)), "mariadb/root-password", secretLength); other == a {
+		t.Error("different keys give the same secret")
+	}
+}
+
+func TestGeneratedSecretsStableInWorkspace(t *testing.T) {
+	dir := writeWorkspace(t, `{}`)
+	t.Setenv("ZHI_WORKSPACE", dir)
+	ctx := context.Background()
+
+	// Commands that read no values create no key.
+	if _, err := newHomeserverPlugin().List(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(secretKeyFile(dir)); !os.IsNotExist(err) {
+		t.Fatalf("key created without reading values, stat err = %v", err)
+	}
+
+	first, _, _ := newHomeserverPlugin().Get(ctx, "mariadb/root-password")
+	second, _, _ := newHomeserverPlugin().Get(ctx, "mariadb/root-password")
+	if first.Val != second.Val {
+		t.Errorf("secret changed between runs: %q != %q", first.Val, second.Val)
+	}
+
+	fi, err := os.Stat(secretKeyFile(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", perm)
+	}
+	// Only the key is written, never the secrets.
+	err = filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil || e.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err == nil && strings.Contains(string(data), first.Val.(string)) {
+			t.Errorf("%s contains the generated secret", path)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratedSecretsNotWrittenOutsideWorkspace(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("ZHI_WORKSPACE", dir)
+	if _, _, err := newHomeserverPlugin().Get(context.Background(), "mariadb/root-password"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".zhi")); !os.IsNotExist(err) {
+		t.Errorf("expected no .zhi directory, stat err = %v", err)
+	}
+}
+
+func TestGeneratedFlagAfterMerge(t *testing.T) {
+	t.Setenv("ZHI_WORKSPACE", writeWorkspace(t, `{}`))
+	p := newHomeserverPlugin()
+	// zhi's LoadTree merges only the saved value, keeping the metadata
+	// from Get.
+	tree := newDefaultTree(t, nil)
+	saved, _ := tree.GetPtr("mariadb/root-password")
+	if saved.Metadata["config.generated"] != true {
+		t.Fatal("generated password not flagged by Get")
+	}
+	saved.Val = "my own password"
+	if err := (computeTransform{p: p}).BeforeDisplay(context.Background(), tree); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"mariadb/root-password", false},
+		{"pihole/admin-password", true},
+	}
+	for _, tt := range tests {
+		v, _ := tree.Get(tt.path)
+		if got := v.Metadata["config.generated"] == true; got != tt.want {
+			t.Errorf("%s: config.generated = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits maps unit prefixes to their multipliers. All units are binary
+// multiples (1K = 1024 bytes), which is how MariaDB, Redis' "kb/mb/gb"
+// units and PHP interpret them.
+var sizeUnits = map[string]int64{
+	"":  1,
+	"k": 1 << 10,
+	"m": 1 << 20,
+	"g": 1 << 30,
+	"t": 1 << 40,
+}
+
+// sizeExprRe matches a number with an optional unit: a unit prefix,
+// optionally followed by "b" or "ib", or a bare "b" for bytes.
+var sizeExprRe = regexp.MustCompile(`^(?i)(\d+(?:\.\d+)?)\s*(?:([kmgt])(?:i?b)?|b)?$`)
+
+// parseSize parses a human-readable size such as "256M", "128mb", "16G",
+// "1.5 GiB" or a plain number of bytes. Case is ignored.
+func parseSize(s string) (int64, error) {
+	m := sizeExprRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("%q is not a size (expected e.g. 256M, 1G or 128mb)", s)
+	}
+	m[2] = strings.ToLower(m[2])
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a size: %w", s, err)
+	}
+	bytes := n * float64(sizeUnits[m[2]])
+	if bytes > math.MaxInt64 {
+		return 0, fmt.Errorf("%q is too large", s)
+	}
+	if bytes != math.Trunc(bytes) {
+		return 0, fmt.Errorf("%q is not a whole number of bytes", s)
+	}
+	return int64(bytes), nil
+}
+
+// sizeSyntax describes how a program expects sizes to be written.
+type sizeSyntax struct {
+	Name     string            // program name for messages
+	Suffixes map[string]string // unit prefix -> suffix written after the number
+}
+
+// sizeSyntaxes lists the size notations of the programs the stack
+// configures, keyed by ValueDef.SizeSyntax.
+var sizeSyntaxes = map[string]sizeSyntax{
+	// MariaDB server options: --innodb-buffer-pool-size=256M
+	"mariadb": {Name: "MariaDB", Suffixes: map[string]string{"k": "K", "m": "M", "g": "G", "t": "T"}},
+	// Redis config: maxmemory 128mb (a bare "m" would mean 10^6 bytes)
+	"redis": {Name: "Redis", Suffixes: map[string]string{"k": "kb", "m": "mb", "g": "gb"}},
+	// PHP ini shorthand: upload_max_filesize=16G
+	"php": {Name: "PHP", Suffixes: map[string]string{"k": "K", "m": "M", "g": "G"}},
+}
+
+// formatSize renders bytes in the notation of the named syntax using the
+// largest unit that represents the value exactly. Unknown syntaxes fall
+// back to the MariaDB style, which is also the generic shorthand.
+func formatSize(bytes int64, syntax string) string {
+	sx, ok := sizeSyntaxes[syntax]
+	if !ok {
+		sx = sizeSyntaxes["mariadb"]
+	}
+	for _, unit := range []string{"t", "g", "m", "k"} {
+		suffix, ok := sx.Suffixes[unit]
+		if !ok {
+			continue
+		}
+		mult := sizeUnits[unit]
+		if bytes != 0 && bytes%mult == 0 {
+			return strconv.FormatInt(bytes/mult, 10) + suffix
+		}
+	}
+	return strconv.FormatInt(bytes, 10)
+}
+
+// normalizeSize parses s and renders it in the given syntax. An empty
+// string stays empty so optional size values can be left unset.
+func normalizeSize(s, syntax string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", nil
+	}
+	n, err := parseSize(s)
+	if err != nil {
+		return "", err
+	}
+	return formatSize(n, syntax), nil
+}
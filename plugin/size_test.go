@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{"256M", 256 << 20, false},
+		{"128mb", 128 << 20, false},
+		{"16G", 16 << 30, false},
+		{"1.5 GiB", 3 << 29, false},
+		{"512k", 512 << 10, false},
+		{"1T", 1 << 40, false},
+		{"1024", 1024, false},
+		{"100b", 100, false},
+		{"2KiB", 2 << 10, false},
+		{"100ib", 0, true},
+		{"", 0, true},
+		{"lots", 0, true},
+		{"-1G", 0, true},
+		{"1.3b", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSize(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %d", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSize(%q) = %d, want %d", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		bytes  int64
+		syntax string
+		want   string
+	}{
+		{256 << 20, "mariadb", "256M"},
+		{256 << 20, "redis", "256mb"},
+		{16 << 30, "php", "16G"},
+		{2 << 40, "mariadb", "2T"},
+		{2 << 40, "redis", "2048gb"},
+		{2 << 40, "php", "2048G"},
+		{1536 << 20, "mariadb", "1536M"},
+		{1000, "redis", "1000"},
+		{0, "php", "0"},
+		{1 << 30, "", "1G"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.bytes, tt.syntax); got != tt.want {
+			t.Errorf("formatSize(%d, %q) = %q, want %q", tt.bytes, tt.syntax, got, tt.want)
+		}
+	}
+}
+
+func TestAllSizeDefsHaveKnownSyntax(t *testing.T) {
+	for _, d := range valueDefs {
+		if d.Type != "size" || d.SizeSyntax == "" {
+			continue
+		}
+		if _, ok := sizeSyntaxes[d.SizeSyntax]; !ok {
+			t.Errorf("path %q: unknown size syntax %q", d.Path, d.SizeSyntax)
+		}
+	}
+}
+
+func TestValidateSizeSyntax(t *testing.T) {
+	d := &ValueDef{Path: "test/maxmemory", Type: "size", SizeSyntax: "redis"}
+	if results := validateSizeSyntax(d, config.Value{Val: "128mb"}); len(results) != 0 {
+		t.Errorf("normalised value should pass, got %v", results)
+	}
+	results := validateSizeSyntax(d, config.Value{Val: "128M"})
+	if len(results) != 1 || results[0].Severity != config.Warning {
+		t.Errorf("expected one warning for 128M in redis notation, got %v", results)
+	}
+	results = validateSizeSyntax(d, config.Value{Val: ""})
+	if len(results) != 1 || results[0].Severity != config.Blocking {
+		t.Errorf("expected one blocking result for an empty size, got %v", results)
+	}
+	optional := &ValueDef{Path: "core/memory-budget", Type: "size"}
+	if results := validateSizeSyntax(optional, config.Value{Val: ""}); len(results) != 0 {
+		t.Errorf("optional size should allow empty, got %v", results)
+	}
+}
+
+func TestValidateMemoryBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides map[string]any
+		warning   bool
+	}{
+		{"unset budget passes", map[string]any{"core/memory-budget": ""}, false},
+		{"defaults fit in 1G", map[string]any{"core/memory-budget": "1G"}, false},
+		{"defaults exceed 256M", map[string]any{"core/memory-budget": "256M"}, true},
+		{"large buffer pool exceeds", map[string]any{"core/memory-budget": "2G", "mariadb/innodb-buffer-pool-size": "2G"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := newDefaultTree(t, tt.overrides)
+			v, _ := tree.Get("core/memory-budget")
+			results, err := validateMemoryBudget(v, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasWarning := len(results) > 0 && results[0].Severity == config.Warning
+			if hasWarning != tt.warning {
+				t.Errorf("warning = %v, want %v (results: %v)", hasWarning, tt.warning, results)
+			}
+		})
+	}
+}
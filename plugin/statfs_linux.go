@@ -0,0 +1,29 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// tmpfsMagic is the f_type of tmpfs, see statfs(2).
+const tmpfsMagic = 0x01021994
+
+// statFS returns the statistics of the file system containing path.
+func statFS(path string) (fsStats, error) {
+	var sfs syscall.Statfs_t
+	if err := syscall.Statfs(path, &sfs); err != nil {
+		return fsStats{}, err
+	}
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return fsStats{}, err
+	}
+	return fsStats{
+		Device:    uint64(st.Dev),
+		Avail:     sfs.Bavail * uint64(sfs.Bsize),
+		Size:      sfs.Blocks * uint64(sfs.Bsize),
+		Files:     sfs.Files,
+		FreeFiles: sfs.Ffree,
+		Tmpfs:     sfs.Type == tmpfsMagic,
+		Supported: true,
+	}, nil
+}
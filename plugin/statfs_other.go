@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// statFS reports no statistics outside Linux; the file system checks are
+// skipped there.
+func statFS(string) (fsStats, error) {
+	return fsStats{}, nil
+}
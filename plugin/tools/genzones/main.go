@@ -0,0 +1,58 @@
+// genzones writes the list of IANA time zone names offered by the plugin
+// for core/timezone. It reads the zoneinfo.zip shipped with the Go
+// toolchain, which is the same database embedded through time/tzdata.
+//
+// Usage (via go generate in the plugin directory):
+//
+//	go run ./tools/genzones -zip $GOROOT/lib/time/zoneinfo.zip -o zones.txt
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+)
+
+// areas are the geographic top-level directories of the tz database.
+// Legacy aliases such as US/Eastern or EST5EDT still validate but are
+// not offered in the dropdown.
+var areas = []string{
+	"Africa", "America", "Antarctica", "Arctic", "Asia",
+	"Atlantic", "Australia", "Europe", "Indian", "Pacific",
+}
+
+func main() {
+	zipPath := flag.String("zip", "", "path to zoneinfo.zip")
+	out := flag.String("o", "zones.txt", "output file")
+	flag.Parse()
+	if *zipPath == "" {
+		log.Fatal("genzones: -zip is required")
+	}
+
+	r, err := zip.OpenReader(*zipPath)
+	if err != nil {
+		log.Fatalf("genzones: %v", err)
+	}
+	defer r.Close()
+
+	names := []string{"UTC"}
+	for _, f := range r.File {
+		area, _, ok := strings.Cut(f.Name, "/")
+		if ok && slices.Contains(areas, area) {
+			names = append(names, f.Name)
+		}
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	for _, n := range names {
+		fmt.Fprintln(&b, n)
+	}
+	if err := os.WriteFile(*out, []byte(b.String()), 0o644); err != nil {
+		log.Fatalf("genzones: %v", err)
+	}
+}
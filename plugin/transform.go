@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"github.com/MrWong99/zhi/pkg/zhiplugin/transform"
+)
+
+// computeTransform is a zhi transform plugin that migrates saved values
+// and recomputes computed values on the assembled tree. zhi fills the
+// tree from the config plugin before it merges saved values, so the
+// plugin never sees them in Get; the transform runs after the merge and
+// sees the values templates and UIs actually get.
+type computeTransform struct {
+	p *homeserverPlugin
+}
+
+// BeforeDisplay migrates values saved for an older schema, marks the
+// passwords that still hold their generated secret, resolves the Derived
+// defaults the user has not replaced against tree and then
+// overwrites every computed value in tree with the result of its Compute
+// function on tree.
+func (t computeTransform) BeforeDisplay(_ context.Context, tree *config.Tree) error {
+	t.p.migrateTree(tree)
+	t.p.markGeneratedTree(tree)
+	t.p.resolveDerived(tree)
+	ctree := t.p.withComponents(tree)
+	for _, path := range tree.List() {
+		d, ok := t.p.defs[path]
+		if !ok || d.Compute == nil {
+			continue
+		}
+		if v, ok := tree.GetPtr(path); ok {
+			v.Val = d.Compute(ctree)
+		}
+	}
+	return nil
+}
+
+// markGeneratedTree sets the config.generated flag on the AutoGenerate
+// values of tree that hold their generated secret and removes it from
+// those holding a saved password of the user's.
+func (p *homeserverPlugin) markGeneratedTree(tree *config.Tree) {
+	p.secretsOnce.Do(p.generateSecrets)
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for path := range p.generated {
+		if v, ok := tree.GetPtr(path); ok {
+			v.Metadata = p.markGenerated(path, v.Val, v.Metadata)
+		}
+	}
+}
+
+// derivedValuesPath records the values that followed their Derived
+// default when the tree was last saved, see recordDerived.
+const derivedValuesPath = "core/derived-values"
+
+// resolveDerived resolves the Derived defaults in tree that follow their
+// sources against tree, so they pick up changes of the sources saved
+// since.
+func (p *homeserverPlugin) resolveDerived(tree *config.Tree) {
+	saved, _ := url.ParseQuery(treeString(tree, derivedValuesPath))
+	for _, path := range tree.List() {
+		d, ok := p.defs[path]
+		if !ok {
+			continue
+		}
+		if _, derived := d.Default.(Derived); !derived {
+			continue
+		}
+		if v, ok := tree.GetPtr(path); ok && p.followsSources(path, v.Val, saved) {
+			v.Val = d.defaultValue(tree)
+		}
+	}
+}
+
+// recordDerived stores the Derived values of tree that equal their
+// default at derivedValuesPath. zhi saves every value, derived ones too;
+// the record tells a saved value that was only derived from one the user
+// set, which keeps its value when the sources change.
+func (p *homeserverPlugin) recordDerived(tree *config.Tree) {
+	rec, ok := tree.GetPtr(derivedValuesPath)
+	if !ok {
+		return
+	}
+	following := url.Values{}
+	for _, path := range p.paths {
+		d := p.defs[path]
+		if _, derived := d.Default.(Derived); !derived {
+			continue
+		}
+		v, ok := tree.Get(path)
+		if !ok {
+			continue
+		}
+		if s, ok := v.Val.(string); ok && s == d.defaultValue(tree) {
+			following.Set(path, s)
+		}
+	}
+	rec.Val = following.Encode()
+}
+
+// followsSources reports whether val, the value zhi assembled for the
+// Derived default at path, leaves it to its sources: it is empty, or it
+// is still the value recorded as derived when the tree was saved (see
+// recordDerived). Trees saved without a record count as following while
+// val is the value the plugin serves, so no saved value replaced it.
+func (p *homeserverPlugin) followsSources(path string, val any, saved url.Values) bool {
+	if val == "" {
+		return true
+	}
+	if len(saved) > 0 {
+		return saved.Has(path) && saved.Get(path) == val
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	v, ok := p.getLocked(path)
+	return ok && v.Val == val
+}
+
+// AfterSave migrates the tree to the current schema version and
+// resolves the Derived defaults that follow their sources, as zhi set
+// saves a tree that BeforeDisplay has not seen, and records them so the
+// next load can tell them from values the user set. Computed values are
+// ephemeral and not saved.
+func (t computeTransform) AfterSave(_ context.Context, tree *config.Tree) error {
+	t.p.migrateTree(tree)
+	t.p.resolveDerived(tree)
+	t.p.recordDerived(tree)
+	return nil
+}
+
+// ValidatePolicy validates after the transform so computed values are
+// checked as templates will see them.
+func (computeTransform) ValidatePolicy(context.Context) (transform.ValidatePolicy, error) {
+	return transform.ValidateAfterTransform, nil
+}
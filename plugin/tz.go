@@ -0,0 +1,20 @@
+package main
+
+import (
+	_ "embed"
+	"strings"
+
+	// Embed the IANA time zone database so core/timezone can be validated
+	// on hosts without /usr/share/zoneinfo.
+	_ "time/tzdata"
+)
+
+//go:generate go run ./tools/genzones -zip $GOROOT/lib/time/zoneinfo.zip -o zones.txt
+
+//go:embed zones.txt
+var zonesTxt string
+
+// timezones lists the time zone names offered for core/timezone, sorted
+// alphabetically. The list is generated from the same database that
+// time/tzdata embeds.
+var timezones = strings.Fields(zonesTxt)
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTimezonesLoad(t *testing.T) {
+	if len(timezones) < 300 {
+		t.Fatalf("only %d time zones embedded", len(timezones))
+	}
+	if !slices.IsSorted(timezones) {
+		t.Error("time zones are not sorted")
+	}
+	for _, want := range []string{"UTC", "Europe/Berlin", "America/New_York"} {
+		if !slices.Contains(timezones, want) {
+			t.Errorf("time zone %q missing", want)
+		}
+	}
+	for _, name := range timezones {
+		if _, err := time.LoadLocation(name); err != nil {
+			t.Errorf("LoadLocation(%q): %v", name, err)
+		}
+	}
+}
+
+func TestTimezoneDefaultIsSelectable(t *testing.T) {
+	for _, d := range valueDefs {
+		if d.Path != "core/timezone" {
+			continue
+		}
+		if !slices.Contains(d.SelectFrom, d.Default.(string)) {
+			t.Errorf("default %v not in SelectFrom", d.Default)
+		}
+		return
+	}
+	t.Fatal("core/timezone not defined")
+}
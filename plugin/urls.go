@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+// webService describes the web interface of a component for the URL
+// catalog.
+type webService struct {
+	Component string // component serving the interface
+	PortPath  string // config path of the host port
+	URLPath   string // path of the interface below the host
+}
+
+// webServices lists the components with a web interface, keyed by
+// component name.
+var webServices = map[string]webService{
+	"pihole":              {Component: "pihole", PortPath: "pihole/web-port", URLPath: "/admin/"},
+	"plex":                {Component: "plex", PortPath: "plex/web-port", URLPath: "/web"},
+	"nextcloud":           {Component: "nextcloud", PortPath: "nextcloud/web-port", URLPath: "/"},
+	"nginx-proxy-manager": {Component: "nginx-proxy-manager", PortPath: "nginx-proxy-manager/admin-port", URLPath: "/"},
+}
+
+// httpsEnabled reports whether services are published through Nginx
+// Proxy Manager with TLS, which requires the proxy and a base domain.
+func httpsEnabled(tree config.TreeReader) bool {
+	return componentEnabled(tree, "nginx-proxy-manager") && treeString(tree, "core/domain") != ""
+}
+
+// serviceHost returns the public host name of a component: its subdomain
+// below core/domain, or the domain itself if the subdomain is empty. It
+// returns "" while no domain is set.
+func serviceHost(tree config.TreeReader, component string) string {
+	domain := treeString(tree, "core/domain")
+	if domain == "" {
+		return ""
+	}
+	if sub := treeString(tree, component+"/subdomain"); sub != "" {
+		return sub + "." + domain
+	}
+	return domain
+}
+
+// serviceURL returns the URL of a component's web interface, or "" if
+// the component is disabled. Behind Nginx Proxy Manager the service is
+// reached through its subdomain over HTTPS; otherwise directly on its
+// host port over HTTP, using the base domain or localhost as host.
+func serviceURL(tree config.TreeReader, component string) string {
+	svc, ok := webServices[component]
+	if !ok || !componentEnabled(tree, svc.Component) {
+		return ""
+	}
+	if httpsEnabled(tree) {
+		return "https://" + serviceHost(tree, component) + portSuffix(tree, "nginx-proxy-manager/https-port", 443) + svc.URLPath
+	}
+	host := treeString(tree, "core/domain")
+	if host == "" {
+		host = "localhost"
+	}
+	return "http://" + host + portSuffix(tree, svc.PortPath, 80) + svc.URLPath
+}
+
+// portSuffix returns ":<port>" for the port at path, or "" if it is the
+// scheme's default port.
+func portSuffix(tree config.TreeReader, path string, defaultPort int) string {
+	v, ok := tree.Get(path)
+	if !ok {
+		return ""
+	}
+	port, ok := toInt(v.Val)
+	if !ok || port == defaultPort {
+		return ""
+	}
+	return fmt.Sprintf(":%d", port)
+}
+
+// urlOf returns a Compute function for the URL of component.
+func urlOf(component string) func(config.TreeReader) any {
+	return func(tree config.TreeReader) any {
+		return serviceURL(tree, component)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+)
+
+func TestServiceURL(t *testing.T) {
+	tests := []struct {
+		name      string
+		state     string
+		overrides map[string]any
+		component string
+		want      string
+	}{
+		{
+			name:      "no domain",
+			state:     `{"nextcloud": true}`,
+			component: "nextcloud",
+			want:      "http://localhost:8080/",
+		},
+		{
+			name:      "domain without proxy",
+			state:     `{"pihole": true}`,
+			overrides: map[string]any{"core/domain": "home.example.com"},
+			component: "pihole",
+			want:      "http://home.example.com:8053/admin/",
+		},
+		{
+			name:      "port 80 without proxy",
+			state:     `{"nextcloud": true}`,
+			overrides: map[string]any{"nextcloud/web-port": 80},
+			component: "nextcloud",
+			want:      "http://localhost/",
+		},
+		{
+			name:      "behind proxy",
+			state:     `{"nextcloud": true, "nginx-proxy-manager": true}`,
+			overrides: map[string]any{"core/domain": "home.example.com"},
+			component: "nextcloud",
+			want:      "https://cloud.home.example.com/",
+		},
+		{
+			name:  "behind proxy on custom https port",
+			state: `{"plex": true, "nginx-proxy-manager": true}`,
+			overrides: map[string]any{
+				"core/domain":                    "home.example.com",
+				"nginx-proxy-manager/https-port": 8443,
+			},
+			component: "plex",
+			want:      "https://plex.home.example.com:8443/web",
+		},
+		{
+			name:  "empty subdomain uses the domain",
+			state: `{"nextcloud": true, "nginx-proxy-manager": true}`,
+			overrides: map[string]any{
+				"core/domain":         "home.example.com",
+				"nextcloud/subdomain": "",
+			},
+			component: "nextcloud",
+			want:      "https://home.example.com/",
+		},
+		{
+			name:      "proxy without domain",
+			state:     `{"nginx-proxy-manager": true}`,
+			component: "nginx-proxy-manager",
+			want:      "http://localhost:81/",
+		},
+		{
+			name:      "disabled component",
+			state:     `{"plex": false}`,
+			component: "plex",
+			want:      "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := loadComponentState(writeWorkspace(t, tt.state))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tree := componentTree{TreeReader: newDefaultTree(t, tt.overrides), state: st}
+			if got := serviceURL(tree, tt.component); got != tt.want {
+				t.Errorf("serviceURL = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginComputedValues(t *testing.T) {
+	t.Setenv("ZHI_WORKSPACE", writeWorkspace(t, `{"nextcloud": true, "nginx-proxy-manager": true}`))
+	p := newHomeserverPlugin()
+	ctx := context.Background()
+
+	v, ok, err := p.Get(ctx, "nextcloud/url")
+	if err != nil || !ok {
+		t.Fatalf("Get found=%v err=%v", ok, err)
+	}
+	if v.Metadata["ui.readonly"] != true || v.Metadata["store.ephemeral"] != true {
+		t.Errorf("computed value metadata = %v", v.Metadata)
+	}
+	if v.Val != "http://localhost:8080/" {
+		t.Errorf("url without domain = %q", v.Val)
+	}
+
+	if err := p.Set(ctx, "core/domain", config.Value{Val: "home.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ = p.Get(ctx, "nextcloud/url"); v.Val != "https://cloud.home.example.com/" {
+		t.Errorf("url with domain = %q", v.Val)
+	}
+	if v, _, _ = p.Get(ctx, "nextcloud/trusted-domains"); v.Val != "home.example.com cloud.home.example.com localhost" {
+		t.Errorf("trusted-domains = %q", v.Val)
+	}
+
+	err = p.Set(ctx, "nextcloud/url", config.Value{Val: "https://elsewhere/"})
+	if err == nil || !strings.Contains(err.Error(), "computed") {
+		t.Errorf("Set on computed value: err = %v", err)
+	}
+}
+
+func TestComputeTransformBeforeDisplay(t *testing.T) {
+	t.Setenv("ZHI_WORKSPACE", writeWorkspace(t, `{"plex": true, "nginx-proxy-manager": true}`))
+	p := newHomeserverPlugin()
+
+	// The tree holds saved values the plugin never saw through Set.
+	tree := newDefaultTree(t, map[string]any{"core/domain": "example.org"})
+	if v, _ := tree.Get("plex/url"); v.Val != "http://localhost:32400/web" {
+		t.Fatalf("precondition: plex/url = %q", v.Val)
+	}
+	if err := (computeTransform{p: p}).BeforeDisplay(context.Background(), tree); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := tree.Get("plex/url"); v.Val != "https://plex.example.org/web" {
+		t.Errorf("plex/url after transform = %q", v.Val)
+	}
+}
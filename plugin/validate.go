@@ -2,7 +2,8 @@ package main
 
 import (
 	"fmt"
-	"strconv"
+	"net/netip"
+	"path/filepath"
 	"strings"
 
 	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
@@ -12,21 +13,176 @@ import (
 // for cross-value checks.
 type validatorFunc func(v config.Value, tree config.TreeReader) ([]config.ValidationResult, error)
 
-// validators maps config paths to their validation functions.
-// Only paths that need validation are listed -- unlisted paths are always valid.
-var validators = map[string]validatorFunc{
-	"core/domain":                    validateRequired,
-	"core/data-root":                 validateAbsolutePath,
-	"pihole/dns-port":                validatePiholeDNSPort,
-	"pihole/admin-password":          validateRequired,
-	"plex/media-movies":              validateOptionalAbsPath,
-	"plex/media-tv":                  validateOptionalAbsPath,
-	"plex/media-music":               validateOptionalAbsPath,
-	"plex/claim-token":               validatePlexClaimToken,
-	"nextcloud/admin-password":       validateRequired,
-	"nextcloud/trusted-domains":      validateTrustedDomains,
-	"mariadb/root-password":          validateRequired,
-	"mariadb/nextcloud-password":     validateRequired,
+// namedValidators holds the validators the validate field of values.yaml
+// can refer to. Validators for required values are added from the
+// required and requiredWith fields.
+var namedValidators = map[string]validatorFunc{
+	"required":          validateRequired,
+	"abs-path":          validateAbsolutePath,
+	"optional-abs-path": validateOptionalAbsPath,
+	"memory-budget":     validateMemoryBudget,
+	"pihole-dns-port":   validatePiholeDNSPort,
+	"plex-claim-token":  validatePlexClaimToken,
+	"trusted-domains":   validateTrustedDomains,
+	"backup-dir":        validateBackupDir,
+	"bind-address":      validateBindAddress,
+}
+
+// treeValidatorFunc validates a path in the context of the whole tree.
+// Unlike validatorFunc it receives the path, so a single function can
+// serve many paths and report on each of them.
+type treeValidatorFunc func(path string, tree config.TreeReader) ([]config.ValidationResult, error)
+
+// treeValidators run for every validated path, after the path-specific
+// validator. Each one decides by itself whether the path is relevant.
+var treeValidators = []treeValidatorFunc{
+	validatePortConflicts,
+	validateSecurityAudit,
+}
+
+// defValidatorFunc validates a value against the constraints declared on
+// its ValueDef.
+type defValidatorFunc func(d *ValueDef, v config.Value) []config.ValidationResult
+
+// defValidators run for every path that has a ValueDef, before the
+// path-specific validator.
+var defValidators = []defValidatorFunc{
+	validateType,
+	validateRange,
+	validateFormat,
+	validateSizeSyntax,
+	validatePasswordStrength,
+	validateUnsafeChars,
+}
+
+// validateType blocks values that cannot be read as the declared type.
+// Set already coerces incoming values, but values restored from a store
+// reach the tree without passing through Set.
+func validateType(d *ValueDef, v config.Value) []config.ValidationResult {
+	if _, err := coerceValue(d, v.Val); err != nil {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Invalid %s value: %v", d.Type, err),
+			Severity: config.Blocking,
+		}}
+	}
+	return nil
+}
+
+// validateRange enforces the Min and Max bounds of int values. Values
+// that are not numbers are left to validateType.
+func validateRange(d *ValueDef, v config.Value) []config.ValidationResult {
+	if d.Min == nil && d.Max == nil {
+		return nil
+	}
+	n, ok := toInt(v.Val)
+	if !ok {
+		return nil
+	}
+	switch {
+	case d.Min != nil && d.Max != nil && (n < *d.Min || n > *d.Max):
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Must be between %d and %d", *d.Min, *d.Max),
+			Severity: config.Blocking,
+		}}
+	case d.Min != nil && n < *d.Min:
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Must be at least %d", *d.Min),
+			Severity: config.Blocking,
+		}}
+	case d.Max != nil && n > *d.Max:
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("Must be at most %d", *d.Max),
+			Severity: config.Blocking,
+		}}
+	}
+	return nil
+}
+
+// quotedValue returns s in quotes for a validation message, or "The
+// value" for passwords, which must not be echoed.
+func quotedValue(d *ValueDef, s string) string {
+	if d.Password {
+		return "The value"
+	}
+	return "'" + s + "'"
+}
+
+// validateFormat checks string values against the Pattern and Format of
+// their ValueDef. Empty values are left to validateRequired; list values
+// are split on Separator and every element is checked.
+func validateFormat(d *ValueDef, v config.Value) []config.ValidationResult {
+	if d.Pattern == nil && d.Format == "" {
+		return nil
+	}
+	s, ok := toString(v.Val)
+	if !ok || strings.TrimSpace(s) == "" {
+		return nil
+	}
+	elems := []string{s}
+	if d.Separator != "" {
+		elems = splitList(s, d.Separator)
+	}
+	var results []config.ValidationResult
+	for _, e := range elems {
+		if d.Pattern != nil && !d.Pattern.MatchString(e) {
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("%s does not match the expected pattern %s", quotedValue(d, e), d.Pattern),
+				Severity: config.Blocking,
+			})
+			continue
+		}
+		if f, ok := formats[d.Format]; ok && !f.Check(e) {
+			results = append(results, config.ValidationResult{
+				Message:  fmt.Sprintf("%s is not valid: expected %s", quotedValue(d, e), f.Hint),
+				Severity: config.Blocking,
+			})
+		}
+	}
+	return results
+}
+
+// validateSizeSyntax warns when a size value is valid but not written in
+// the notation of the program it is passed to. Values set through Set are
+// normalised already; this catches values restored from a store. Sizes
+// passed to a program cannot be empty; only sizes without a SizeSyntax,
+// such as core/memory-budget, are optional.
+func validateSizeSyntax(d *ValueDef, v config.Value) []config.ValidationResult {
+	if d.Type != "size" {
+		return nil
+	}
+	s, ok := v.Val.(string)
+	if !ok {
+		return nil
+	}
+	if strings.TrimSpace(s) == "" {
+		if d.SizeSyntax == "" {
+			return nil
+		}
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("A size is required; %s does not accept an empty one. Use e.g. '%s'.", sizeSyntaxes[d.SizeSyntax].Name, formatSize(256<<20, d.SizeSyntax)),
+			Severity: config.Blocking,
+		}}
+	}
+	norm, err := normalizeSize(s, d.SizeSyntax)
+	if err != nil || norm == s {
+		return nil
+	}
+	return []config.ValidationResult{{
+		Message:  fmt.Sprintf("'%s' is not in %s notation and is passed on verbatim. Use '%s' instead.", s, sizeSyntaxes[d.SizeSyntax].Name, norm),
+		Severity: config.Warning,
+	}}
+}
+
+// splitList splits a list value on sep, trimming whitespace and dropping
+// empty elements.
+func splitList(s, sep string) []string {
+	var out []string
+	for e := range strings.SplitSeq(s, sep) {
+		if e = strings.TrimSpace(e); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
 }
 
 func validateRequired(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
@@ -40,6 +196,21 @@ func validateRequired(v config.Value, _ config.TreeReader) ([]config.ValidationR
 	return nil, nil
 }
 
+// requiredWith returns a validator that requires a value only while one
+// of the given components is enabled. It is meant for shared values that
+// some services depend on; values owned by a component are skipped
+// anyway while that component is disabled.
+func requiredWith(components ...string) validatorFunc {
+	return func(v config.Value, tree config.TreeReader) ([]config.ValidationResult, error) {
+		for _, c := range components {
+			if componentEnabled(tree, c) {
+				return validateRequired(v, tree)
+			}
+		}
+		return nil, nil
+	}
+}
+
 func validateAbsolutePath(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
 	s, _ := v.Val.(string)
 	if !strings.HasPrefix(s, "/") {
@@ -62,6 +233,50 @@ func validateOptionalAbsPath(v config.Value, _ config.TreeReader) ([]config.Vali
 	return nil, nil
 }
 
+// validateBackupDir blocks a backup directory inside core/data-root:
+// backup.sh copies from the data root, so every backup would contain the
+// earlier ones.
+func validateBackupDir(v config.Value, tree config.TreeReader) ([]config.ValidationResult, error) {
+	backup, _ := v.Val.(string)
+	dataRoot := treeString(tree, "core/data-root")
+	if !filepath.IsAbs(backup) || !filepath.IsAbs(dataRoot) {
+		return nil, nil
+	}
+	rel, err := filepath.Rel(filepath.Clean(dataRoot), filepath.Clean(backup))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil, nil
+	}
+	return []config.ValidationResult{{
+		Message:  fmt.Sprintf("The backup directory is inside the data root %s, so each backup would copy the earlier ones. Choose a directory outside of it, ideally on another disk.", dataRoot),
+		Severity: config.Blocking,
+	}}, nil
+}
+
+// validateBindAddress checks a host address ports are published on. It
+// must be empty (all interfaces), unspecified, loopback or a private or
+// link-local address: the stack is meant for the LAN, and Docker can only
+// bind addresses of the host.
+func validateBindAddress(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
+	s, _ := v.Val.(string)
+	if s == "" {
+		return nil, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil || addr.Zone() != "" {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("%q is not an IP address. Use an address of this host such as 192.168.1.10, 127.0.0.1 for this machine only, or leave it empty for all interfaces.", s),
+			Severity: config.Blocking,
+		}}, nil
+	}
+	if !addr.IsUnspecified() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("%s is not a local address. Use the LAN address of this host or 127.0.0.1.", s),
+			Severity: config.Blocking,
+		}}, nil
+	}
+	return nil, nil
+}
+
 func validatePlexClaimToken(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
 	s, _ := v.Val.(string)
 	if s == "" {
@@ -86,18 +301,59 @@ func validateTrustedDomains(v config.Value, tree config.TreeReader) ([]config.Va
 	return nil, nil
 }
 
-func validatePiholeDNSPort(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
-	s := fmt.Sprintf("%v", v.Val)
-	port, err := strconv.ParseFloat(s, 64)
+// memoryConsumers lists the size values that reserve host memory, keyed
+// by the component they belong to.
+var memoryConsumers = []struct {
+	Component string
+	Path      string
+}{
+	{"mariadb", "mariadb/innodb-buffer-pool-size"},
+	{"redis", "redis/maxmemory"},
+}
+
+// configuredMemory sums the memory reserved by the enabled components and
+// describes the contributing values for messages.
+func configuredMemory(tree config.TreeReader) (total int64, parts []string) {
+	for _, c := range memoryConsumers {
+		if !componentEnabled(tree, c.Component) {
+			continue
+		}
+		ms := treeString(tree, c.Path)
+		n, err := parseSize(ms)
+		if err != nil {
+			continue
+		}
+		total += n
+		parts = append(parts, fmt.Sprintf("%s=%s", c.Path, ms))
+	}
+	return total, parts
+}
+
+func validateMemoryBudget(v config.Value, tree config.TreeReader) ([]config.ValidationResult, error) {
+	s, _ := v.Val.(string)
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	budget, err := parseSize(s)
 	if err != nil {
+		return nil, nil // reported by validateType
+	}
+	total, parts := configuredMemory(tree)
+	if total > budget {
 		return []config.ValidationResult{{
-			Message:  "DNS port must be a number",
-			Severity: config.Blocking,
+			Message:  fmt.Sprintf("Configured memory (%s, total %s) exceeds the memory budget of %s. Lower the settings or raise the budget.", strings.Join(parts, ", "), formatSize(total, ""), formatSize(budget, "")),
+			Severity: config.Warning,
 		}}, nil
 	}
-	if port == 53 {
+	return nil, nil
+}
+
+// validatePiholeDNSPort blocks a DNS port that is not a number. Whether
+// PiHole can bind it on the host is a host check, see checkDNSPort.
+func validatePiholeDNSPort(v config.Value, _ config.TreeReader) ([]config.ValidationResult, error) {
+	if _, ok := toInt(v.Val); !ok {
 		return []config.ValidationResult{{
-			Message:  "Port 53 may conflict with systemd-resolved. Run: sudo mkdir -p /etc/systemd/resolved.conf.d && echo -e '[Resolve]\\nDNSStubListener=no' | sudo tee /etc/systemd/resolved.conf.d/no-stub.conf && sudo systemctl restart systemd-resolved",
+			Message:  "DNS port must be a number",
 			Severity: config.Blocking,
 		}}, nil
 	}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
 	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
@@ -81,44 +82,41 @@ func TestValidateOptionalAbsPath(t *testing.T) {
 
 func TestValidatePiholeDNSPort(t *testing.T) {
 	tests := []struct {
-		name     string
 		val      any
-		severity config.Severity
-		hasResult bool
+		blocking bool
 	}{
-		{"port 53 blocks", 53, config.Blocking, true},
-		{"port 5353 passes", 5353, 0, false},
-		{"non-number blocks", "abc", config.Blocking, true},
-		{"float port passes", 8053.0, 0, false},
+		{53, false},
+		{8053.0, false},
+		{"abc", true},
 	}
 	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
+		t.Run(fmt.Sprint(tt.val), func(t *testing.T) {
 			results, err := validatePiholeDNSPort(config.Value{Val: tt.val}, nil)
 			if err != nil {
 				t.Fatal(err)
 			}
-			if tt.hasResult {
-				if len(results) == 0 {
-					t.Fatal("expected validation result, got none")
-				}
-				if results[0].Severity != tt.severity {
-					t.Errorf("severity = %v, want %v", results[0].Severity, tt.severity)
-				}
-			} else if len(results) > 0 {
-				t.Errorf("expected no results, got %v", results)
+			hasBlocking := len(results) > 0 && results[0].Severity == config.Blocking
+			if hasBlocking != tt.blocking {
+				t.Errorf("blocking = %v, want %v (%v)", hasBlocking, tt.blocking, results)
 			}
 		})
 	}
 }
 
-func TestValidatorsMapOnlyReferencesKnownPaths(t *testing.T) {
-	known := make(map[string]bool, len(valueDefs))
-	for _, d := range valueDefs {
-		known[d.Path] = true
-	}
-	for path := range validators {
-		if !known[path] {
-			t.Errorf("validator registered for unknown path: %s", path)
+func TestNamedValidatorsAreUsed(t *testing.T) {
+	specs, err := mergeValueSpecs(nil, valuesYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := map[string]bool{}
+	for _, s := range specs {
+		for _, name := range s.Validate {
+			used[name] = true
+		}
+	}
+	for name := range namedValidators {
+		if !used[name] {
+			t.Errorf("validator %s is not used by any value", name)
 		}
 	}
 }
@@ -157,3 +155,116 @@ func TestPluginValidateDispatch(t *testing.T) {
 		t.Errorf("expected no results for core/timezone, got %v", results)
 	}
 }
+
+func TestValidateRange(t *testing.T) {
+	port := &ValueDef{Path: "test/port", Type: "int", Min: new(1), Max: new(65535)}
+	days := &ValueDef{Path: "test/days", Type: "int", Min: new(1)}
+	free := &ValueDef{Path: "test/free", Type: "int"}
+	tests := []struct {
+		name     string
+		def      *ValueDef
+		val      any
+		blocking bool
+	}{
+		{"port in range passes", port, 8080, false},
+		{"port at upper bound passes", port, 65535, false},
+		{"port above range blocks", port, 99999, true},
+		{"port zero blocks", port, 0, true},
+		{"min only passes", days, 7, false},
+		{"min only blocks negative", days, -1, true},
+		{"unbounded passes", free, -5, false},
+		{"non-number is left to type check", port, "abc", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := validateRange(tt.def, config.Value{Val: tt.val})
+			hasBlocking := len(results) > 0 && results[0].Severity == config.Blocking
+			if hasBlocking != tt.blocking {
+				t.Errorf("blocking = %v, want %v (results: %v)", hasBlocking, tt.blocking, results)
+			}
+		})
+	}
+}
+
+func TestPluginValidateAppliesDefConstraints(t *testing.T) {
+	p := newHomeserverPlugin()
+	tree := newDefaultTree(t, map[string]any{
+		"nextcloud/web-port":      99999,
+		"core/backup-retain-days": 0,
+		"pihole/dnssec":           "sometimes",
+	})
+	for _, path := range []string{"nextcloud/web-port", "core/backup-retain-days", "pihole/dnssec"} {
+		results, err := p.Validate(context.Background(), path, tree)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) == 0 || results[0].Severity != config.Blocking {
+			t.Errorf("Validate(%q) = %v, want a blocking result", path, results)
+		}
+	}
+}
+
+func TestValidateBackupDir(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataRoot string
+		backup   string
+		blocking bool
+	}{
+		{"separate directories", "/srv/homeserver", "/srv/backups/homeserver", false},
+		{"inside data root", "/srv/homeserver", "/srv/homeserver/backups", true},
+		{"nested deeper", "/srv/homeserver/", "/srv/homeserver/nextcloud/backups", true},
+		{"same directory", "/srv/homeserver", "/srv/homeserver/.", true},
+		{"shared prefix only", "/srv/home", "/srv/homeserver-backups", false},
+		{"data root inside backup dir", "/srv/backups/data", "/srv/backups", false},
+		{"relative paths", "srv/homeserver", "srv/homeserver/backups", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tree := config.NewTree()
+			tree.Set("core/data-root", &config.Value{Val: tt.dataRoot})
+			results, err := validateBackupDir(config.Value{Val: tt.backup}, tree)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasBlocking := len(results) > 0 && results[0].Severity == config.Blocking
+			if hasBlocking != tt.blocking {
+				t.Errorf("blocking = %v, want %v", hasBlocking, tt.blocking)
+			}
+		})
+	}
+}
+
+func TestValidateBindAddress(t *testing.T) {
+	tests := []struct {
+		val      any
+		blocking bool
+	}{
+		{"", false},
+		{"0.0.0.0", false},
+		{"::", false},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"192.168.1.10", false},
+		{"10.0.0.5", false},
+		{"fd00::10", false},
+		{"fe80::1", false},
+		{"8.8.8.8", true},
+		{"2001:db8::1", true},
+		{"fe80::1%eth0", true},
+		{"server.local", true},
+		{"192.168.1.10:53", true},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.val), func(t *testing.T) {
+			results, err := validateBindAddress(config.Value{Val: tt.val}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hasBlocking := len(results) > 0 && results[0].Severity == config.Blocking
+			if hasBlocking != tt.blocking {
+				t.Errorf("blocking = %v, want %v (%v)", hasBlocking, tt.blocking, results)
+			}
+		})
+	}
+}
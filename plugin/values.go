@@ -2,367 +2,263 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"slices"
 	"sync"
 
 	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
 )
 
-// valueDefs contains all configuration values for the home server workspace.
-var valueDefs = []ValueDef{
-	// ── core ──────────────────────────────────────────────────────────────
-	{
-		Path: "core/timezone", Default: "Europe/Berlin",
-		Section: "General", DisplayName: "Timezone",
-		Description: "System timezone for all containers (TZ database name)",
-		Type:        "string", Placeholder: "Europe/Berlin",
-	},
-	{
-		Path: "core/domain", Default: "",
-		Section: "General", DisplayName: "Base Domain",
-		Description: "Base domain for service URLs (e.g., home.example.com)",
-		Type:        "string", Placeholder: "home.example.com", Required: true,
-	},
-	{
-		Path: "core/data-root", Default: "/srv/homeserver",
-		Section: "Storage", DisplayName: "Data Root Path",
-		Description: "Base directory for bind-mounted service data on the host",
-		Type:        "string", Placeholder: "/srv/homeserver",
-	},
-	{
-		Path: "core/backup-dir", Default: "/srv/backups/homeserver",
-		Section: "Backups", DisplayName: "Backup Directory",
-		Description: "Directory to store backup archives",
-		Type:        "string", Placeholder: "/srv/backups/homeserver",
-	},
-	{
-		Path: "core/backup-retain-days", Default: 7,
-		Section: "Backups", DisplayName: "Backup Retention (days)",
-		Description: "Number of days to keep old backups before automatic cleanup",
-		Type:        "int",
-	},
-	{
-		Path: "core/compose-project-name", Default: "home-server",
-		Section: "General", DisplayName: "Compose Project Name",
-		Description: "Docker Compose project name (used for container/network naming)",
-		Type:        "string",
-	},
+// homeserverPlugin implements config.Plugin.
+type homeserverPlugin struct {
+	mu     sync.RWMutex
+	paths  []string
+	defs   map[string]*ValueDef
+	values map[string]*config.Value
 
-	// ── pihole ────────────────────────────────────────────────────────────
-	{
-		Path: "pihole/image-tag", Default: "latest",
-		Section: "Image", DisplayName: "PiHole Image Tag",
-		Description: "Docker image tag for pihole/pihole",
-		Type:        "string",
-	},
-	{
-		Path: "pihole/dns-port", Default: 53,
-		Section: "Network", DisplayName: "DNS Port",
-		Description: "Host port for DNS (UDP/TCP)",
-		Type:        "int",
-	},
-	{
-		Path: "pihole/web-port", Default: 8053,
-		Section: "Network", DisplayName: "Web Admin Port",
-		Description: "Host port for PiHole web admin interface",
-		Type:        "int",
-	},
-	{
-		Path: "pihole/admin-password", Default: "",
-		Section: "Security", DisplayName: "Admin Password",
-		Description: "Password for the PiHole web admin interface",
-		Type:        "string", Password: true, Required: true,
-	},
-	{
-		Path: "pihole/upstream-dns", Default: "1.1.1.1;8.8.8.8",
-		Section: "DNS", DisplayName: "Upstream DNS Servers",
-		Description: "Semicolon-separated upstream DNS servers (passed to PiHole v6 FTLCONF_dns_upstreams)",
-		Type:        "string", Placeholder: "1.1.1.1;8.8.8.8",
-	},
-	{
-		Path: "pihole/dnssec", Default: true,
-		Section: "DNS", DisplayName: "Enable DNSSEC",
-		Description: "Enable DNSSEC validation for DNS queries",
-		Type:        "bool",
-	},
-	{
-		Path: "pihole/custom-blocklists", Default: "",
-		Section: "DNS", DisplayName: "Custom Blocklists",
-		Description: "Comma-separated URLs for additional blocklists",
-		Type:        "string", Placeholder: "https://example.com/blocklist.txt",
-	},
+	// workspaceDir is the zhi workspace whose component state decides
+	// which services are validated.
+	workspaceDir string
 
-	// ── plex ──────────────────────────────────────────────────────────────
-	{
-		Path: "plex/image-tag", Default: "latest",
-		Section: "Image", DisplayName: "Plex Image Tag",
-		Description: "Docker image tag for linuxserver/plex",
-		Type:        "string",
-	},
-	{
-		Path: "plex/web-port", Default: 32400,
-		Section: "Network", DisplayName: "Web UI Port",
-		Description: "Host port for Plex web interface",
-		Type:        "int",
-	},
-	{
-		Path: "plex/claim-token", Default: "",
-		Section: "Account", DisplayName: "Claim Token",
-		Description: "Plex claim token from https://plex.tv/claim (valid 4 minutes)",
-		Type:        "string", Password: true, Placeholder: "claim-xxxxxxxxxxxxxxxxxxxx",
-	},
-	{
-		Path: "plex/puid", Default: 1000,
-		Section: "Permissions", DisplayName: "PUID",
-		Description: "User ID for file ownership inside the container",
-		Type:        "int",
-	},
-	{
-		Path: "plex/pgid", Default: 1000,
-		Section: "Permissions", DisplayName: "PGID",
-		Description: "Group ID for file ownership inside the container",
-		Type:        "int",
-	},
-	{
-		Path: "plex/media-movies", Default: "/mnt/media/movies",
-		Section: "Media Libraries", DisplayName: "Movies Path",
-		Description: "Host path to movies library",
-		Type:        "string", Placeholder: "/mnt/media/movies",
-	},
-	{
-		Path: "plex/media-tv", Default: "/mnt/media/tv",
-		Section: "Media Libraries", DisplayName: "TV Shows Path",
-		Description: "Host path to TV shows library",
-		Type:        "string", Placeholder: "/mnt/media/tv",
-	},
-	{
-		Path: "plex/media-music", Default: "/mnt/media/music",
-		Section: "Media Libraries", DisplayName: "Music Path",
-		Description: "Host path to music library",
-		Type:        "string", Placeholder: "/mnt/media/music",
-	},
-	{
-		Path: "plex/hardware-transcoding", Default: false,
-		Section: "Transcoding", DisplayName: "Hardware Transcoding",
-		Description: "Enable hardware transcoding via /dev/dri (Intel Quick Sync / AMD VCE)",
-		Type:        "bool",
-	},
+	// generated holds the secrets generated for AutoGenerate values.
+	// secretsOnce guards their generation, see generateSecrets.
+	generated   map[string]string
+	secretsOnce sync.Once
 
-	// ── nextcloud ─────────────────────────────────────────────────────────
-	{
-		Path: "nextcloud/image-tag", Default: "latest",
-		Section: "Image", DisplayName: "Nextcloud Image Tag",
-		Description: "Docker image tag for nextcloud",
-		Type:        "string",
-	},
-	{
-		Path: "nextcloud/web-port", Default: 8080,
-		Section: "Network", DisplayName: "Web Port",
-		Description: "Host port for Nextcloud web interface",
-		Type:        "int",
-	},
-	{
-		Path: "nextcloud/admin-user", Default: "admin",
-		Section: "Admin Account", DisplayName: "Admin Username",
-		Description: "Nextcloud admin username (set during first run only)",
-		Type:        "string",
-	},
-	{
-		Path: "nextcloud/admin-password", Default: "",
-		Section: "Admin Account", DisplayName: "Admin Password",
-		Description: "Nextcloud admin password (set during first run only)",
-		Type:        "string", Password: true, Required: true,
-	},
-	{
-		Path: "nextcloud/trusted-domains", Default: "localhost",
-		Section: "Security", DisplayName: "Trusted Domains",
-		Description: "Space-separated list of trusted domains for Nextcloud",
-		Type:        "string", Placeholder: "localhost cloud.home.example.com",
-	},
-	{
-		Path: "nextcloud/max-upload-size", Default: "16G",
-		Section: "Uploads", DisplayName: "Max Upload Size",
-		Description: "Maximum file upload size (e.g., 512M, 1G, 16G)",
-		Type:        "string",
-	},
-	{
-		Path: "nextcloud/redis-file-locking", Default: true,
-		Section: "Performance", DisplayName: "Redis File Locking",
-		Description: "Use Redis for transactional file locking (recommended when Redis is available)",
-		Type:        "bool",
-	},
-	{
-		Path: "nextcloud/smtp-host", Default: "",
-		Section: "Email (SMTP)", DisplayName: "SMTP Host",
-		Description: "SMTP server hostname for sending emails",
-		Type:        "string", Placeholder: "smtp.example.com",
-	},
-	{
-		Path: "nextcloud/smtp-port", Default: 587,
-		Section: "Email (SMTP)", DisplayName: "SMTP Port",
-		Description: "SMTP server port",
-		Type:        "int",
-	},
-	{
-		Path: "nextcloud/smtp-user", Default: "",
-		Section: "Email (SMTP)", DisplayName: "SMTP Username",
-		Description: "SMTP authentication username",
-		Type:        "string",
-	},
-	{
-		Path: "nextcloud/smtp-password", Default: "",
-		Section: "Email (SMTP)", DisplayName: "SMTP Password",
-		Description: "SMTP authentication password",
-		Type:        "string", Password: true,
-	},
+	// overridden records the paths with a Derived default that the user
+	// has set to a value of their own.
+	overridden map[string]bool
 
-	// ── mariadb ───────────────────────────────────────────────────────────
-	{
-		Path: "mariadb/image-tag", Default: "11",
-		Section: "Image", DisplayName: "MariaDB Image Tag",
-		Description: "Docker image tag for mariadb",
-		Type:        "string",
-	},
-	{
-		Path: "mariadb/root-password", Default: "",
-		Section: "Security", DisplayName: "Root Password",
-		Description: "MariaDB root password (set during first run only)",
-		Type:        "string", Password: true, Required: true,
-	},
-	{
-		Path: "mariadb/nextcloud-db", Default: "nextcloud",
-		Section: "Nextcloud Database", DisplayName: "Database Name",
-		Description: "Database name for Nextcloud",
-		Type:        "string",
-	},
-	{
-		Path: "mariadb/nextcloud-user", Default: "nextcloud",
-		Section: "Nextcloud Database", DisplayName: "Database User",
-		Description: "Database user for Nextcloud",
-		Type:        "string",
-	},
-	{
-		Path: "mariadb/nextcloud-password", Default: "",
-		Section: "Nextcloud Database", DisplayName: "Database Password",
-		Description: "Database password for the Nextcloud user",
-		Type:        "string", Password: true, Required: true,
-	},
-	{
-		Path: "mariadb/enable-binlog", Default: false,
-		Section: "Replication", DisplayName: "Enable Binary Logging",
-		Description: "Enable binary logging for replication. Disabled by default to save disk space on home servers.",
-		Type:        "bool",
-	},
-	{
-		Path: "mariadb/innodb-buffer-pool-size", Default: "256M",
-		Section: "Tuning", DisplayName: "InnoDB Buffer Pool Size",
-		Description: "InnoDB buffer pool size (e.g., 256M, 1G)",
-		Type:        "string", Placeholder: "256M",
-	},
+	// components overrides the component state read from workspaceDir,
+	// for commands that render or validate a chosen set of components.
+	components *componentState
 
-	// ── redis ─────────────────────────────────────────────────────────────
-	{
-		Path: "redis/image-tag", Default: "8-alpine",
-		Section: "Image", DisplayName: "Redis Image Tag",
-		Description: "Docker image tag for redis",
-		Type:        "string",
-	},
-	{
-		Path: "redis/maxmemory", Default: "128mb",
-		Section: "Memory", DisplayName: "Max Memory",
-		Description: "Maximum memory Redis can use (e.g., 128mb, 256mb)",
-		Type:        "string", Placeholder: "128mb",
-	},
-	{
-		Path: "redis/maxmemory-policy", Default: "allkeys-lru",
-		Section: "Memory", DisplayName: "Eviction Policy",
-		Description: "How Redis evicts keys when maxmemory is reached",
-		Type:        "string",
-		SelectFrom:  []string{"allkeys-lru", "volatile-lru", "allkeys-lfu", "volatile-lfu", "noeviction"},
-	},
+	// stateCache holds the component state read from workspaceDir.
+	stateCache componentCache
 
-	// ── nginx-proxy-manager ───────────────────────────────────────────────
-	{
-		Path: "nginx-proxy-manager/image-tag", Default: "latest",
-		Section: "Image", DisplayName: "NPM Image Tag",
-		Description: "Docker image tag for jc21/nginx-proxy-manager",
-		Type:        "string",
-	},
-	{
-		Path: "nginx-proxy-manager/http-port", Default: 80,
-		Section: "Ports", DisplayName: "HTTP Port",
-		Description: "Host port for HTTP traffic",
-		Type:        "int",
-	},
-	{
-		Path: "nginx-proxy-manager/https-port", Default: 443,
-		Section: "Ports", DisplayName: "HTTPS Port",
-		Description: "Host port for HTTPS traffic",
-		Type:        "int",
-	},
-	{
-		Path: "nginx-proxy-manager/admin-port", Default: 81,
-		Section: "Ports", DisplayName: "Admin UI Port",
-		Description: "Host port for NPM admin web interface",
-		Type:        "int",
-	},
-	{
-		Path: "nginx-proxy-manager/letsencrypt-email", Default: "",
-		Section: "SSL", DisplayName: "Let's Encrypt Email",
-		Description: "Email for Let's Encrypt certificate notifications",
-		Type:        "string", Placeholder: "admin@example.com",
-	},
-}
+	// host inspects the host for validation, if host checks are enabled.
+	host *hostInspector
 
-// homeserverPlugin implements config.Plugin.
-type homeserverPlugin struct {
-	mu     sync.RWMutex
-	paths  []string
-	values map[string]*config.Value
+	// loadErr is set if the values overlay could not be applied. The
+	// plugin then serves the embedded definitions only.
+	loadErr error
 }
 
 func newHomeserverPlugin() *homeserverPlugin {
+	return newWorkspacePlugin(workspaceDir())
+}
+
+// newWorkspacePlugin returns a plugin for the zhi workspace in dir, with
+// the values overlay configured for it applied.
+func newWorkspacePlugin(dir string) *homeserverPlugin {
+	defs, err := loadValueDefs(dir)
 	p := &homeserverPlugin{
-		values: make(map[string]*config.Value, len(valueDefs)),
-		paths:  make([]string, 0, len(valueDefs)),
+		defs:   make(map[string]*ValueDef, len(defs)),
+		values: make(map[string]*config.Value, len(defs)),
+		paths:  make([]string, 0, len(defs)),
+
+		workspaceDir: dir,
+		generated:    make(map[string]string),
+		overridden:   make(map[string]bool),
+		loadErr:      err,
+	}
+	if hostChecksEnabled() {
+		p.host = localHost
 	}
-	for _, v := range valueDefs {
+	for i := range defs {
+		v := &defs[i]
 		p.paths = append(p.paths, v.Path)
+		p.defs[v.Path] = v
 		p.values[v.Path] = v.ToValue()
 	}
 	return p
 }
 
 func (p *homeserverPlugin) List(_ context.Context) ([]string, error) {
-	return p.paths, nil
+	// Old paths of renamed values are listed too, so zhi loads their
+	// saved values and the transform can move them to the new path.
+	return append(slices.Clone(p.paths), deprecatedPaths()...), nil
 }
 
 func (p *homeserverPlugin) Get(_ context.Context, path string) (config.Value, bool, error) {
+	p.secretsOnce.Do(p.generateSecrets)
 	p.mu.RLock()
 	defer p.mu.RUnlock()
+	v, ok := p.getLocked(path)
+	return v, ok, nil
+}
+
+// getLocked returns the effective value at path. Values with a Derived
+// default are resolved from the current values unless the user has
+// overridden them. The caller must hold p.mu.
+func (p *homeserverPlugin) getLocked(path string) (config.Value, bool) {
 	v, ok := p.values[path]
 	if !ok {
-		return config.Value{}, false, nil
+		return p.getDeprecated(path)
+	}
+	out := *v
+	d, ok := p.defs[path]
+	if ok && d.AutoGenerate {
+		out.Metadata = p.markGenerated(path, out.Val, out.Metadata)
+	}
+	switch {
+	case !ok:
+	case d.Compute != nil:
+		out.Val = d.Compute(p.withComponents(pluginTree{p}))
+	case !p.overridden[path]:
+		if _, derived := d.Default.(Derived); derived {
+			out.Val = d.defaultValue(pluginTree{p})
+		}
+	}
+	return out, true
+}
+
+// getDeprecated returns a placeholder for the old path of a renamed
+// value. It carries the default of the new path so that zhi accepts a
+// saved value of the same type.
+func (p *homeserverPlugin) getDeprecated(path string) (config.Value, bool) {
+	for _, m := range migrations {
+		if m.From != path {
+			continue
+		}
+		cur, ok := p.values[m.Path]
+		if !ok {
+			break
+		}
+		return config.Value{
+			Val: cur.Val,
+			Metadata: map[string]any{
+				"ui.displayName":   path,
+				"core.description": fmt.Sprintf("Renamed to %s", m.Path),
+				"core.type":        cur.Metadata["core.type"],
+				"ui.readonly":      true,
+				"config.renamedTo": m.Path,
+			},
+		}, true
 	}
-	return *v, true, nil
+	return config.Value{}, false
 }
 
 func (p *homeserverPlugin) Set(_ context.Context, path string, v config.Value) error {
 	if err := config.ValidatePath(path); err != nil {
 		return err
 	}
+	p.secretsOnce.Do(p.generateSecrets)
+	// Values written under an old path or in an old format are migrated
+	// like saved ones: they move to the current path and are converted
+	// to the current format.
+	if newPath, val, records := migrateValue(path, v.Val, 0); len(records) > 0 {
+		md := v.Metadata
+		if newPath != path {
+			p.mu.RLock()
+			if cur, ok := p.values[newPath]; ok {
+				md = cur.Metadata
+			}
+			p.mu.RUnlock()
+		}
+		path, v.Val, v.Metadata = newPath, val, annotateMigrated(md, records)
+	}
+	// Known paths are normalised to their declared type so templates and
+	// validators always see the same Go type as the default.
+	if d, ok := p.defs[path]; ok {
+		if d.Compute != nil {
+			return fmt.Errorf("%s is computed from other values and cannot be set", path)
+		}
+		val, err := coerceValue(d, v.Val)
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", path, err)
+		}
+		v.Val = val
+	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	// Setting a derived value to empty or to what it would derive to
+	// anyway lets it follow its sources again.
+	if d, ok := p.defs[path]; ok {
+		if _, derived := d.Default.(Derived); derived {
+			p.overridden[path] = v.Val != "" && v.Val != d.defaultValue(pluginTree{p})
+		}
+	}
 	p.values[path] = &v
 	return nil
 }
 
 func (p *homeserverPlugin) Validate(_ context.Context, path string, tree config.TreeReader) ([]config.ValidationResult, error) {
-	if fn, ok := validators[path]; ok {
-		v, found := tree.Get(path)
-		if !found {
-			return nil, nil
+	v, found := tree.Get(path)
+	if !found {
+		return nil, nil
+	}
+	// zhi validates the unfiltered tree, so values of disabled services
+	// are skipped here to keep their rules from blocking.
+	tree = p.withComponents(tree)
+	if c := pathComponent(tree, path); c != "" && !componentEnabled(tree, c) {
+		return nil, nil
+	}
+	var results []config.ValidationResult
+	if to, ok := v.Metadata["config.renamedTo"].(string); ok {
+		return []config.ValidationResult{{
+			Message:  fmt.Sprintf("This value was renamed to %s and is no longer used. Enable the homeserver transform to migrate it, or set %s.", to, to),
+			Severity: config.Warning,
+		}}, nil
+	}
+	for _, n := range migratedNotes(v.Metadata) {
+		results = append(results, config.ValidationResult{
+			Message:  "Migrated from an older configuration: " + n,
+			Severity: config.Info,
+		})
+	}
+	if d, ok := p.defs[path]; ok {
+		for _, fn := range defValidators {
+			results = append(results, fn(d, v)...)
+		}
+		for _, fn := range d.Validators {
+			r, err := fn(v, tree)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, r...)
 		}
-		return fn(v, tree)
 	}
-	return nil, nil
+	for _, fn := range treeValidators {
+		r, err := fn(path, tree)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r...)
+	}
+	r, err := p.validatePasswordReuse(path, tree)
+	if err != nil {
+		return nil, err
+	}
+	results = append(results, r...)
+	if r, err = p.validateCompose(path, tree); err != nil {
+		return nil, err
+	}
+	results = append(results, r...)
+	if p.host != nil {
+		for _, fn := range hostChecks {
+			results = append(results, fn(p.host, path, tree)...)
+		}
+	}
+	return results, nil
+}
+
+// withComponents attaches the workspace's component state to tree. If the
+// state cannot be read, tree is returned unchanged and validators fall
+// back to inspecting which values are present.
+func (p *homeserverPlugin) withComponents(tree config.TreeReader) config.TreeReader {
+	st, err := p.componentState()
+	if err != nil {
+		return tree
+	}
+	return componentTree{TreeReader: tree, state: st}
+}
+
+// componentState returns the component state set on p, or else the one
+// of its workspace.
+func (p *homeserverPlugin) componentState() (*componentState, error) {
+	if p.components != nil {
+		return p.components, nil
+	}
+	return p.stateCache.load(p.workspaceDir)
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"regexp"
 	"testing"
 
 	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
@@ -48,7 +49,7 @@ func TestToValueMetadata(t *testing.T) {
 		Section: "General", DisplayName: "Test",
 		Description: "A test value", Type: "string",
 		Placeholder: "enter value", Password: true, Required: true,
-		SelectFrom: []string{"a", "b"},
+		SelectFrom: []string{"a", "b"}, Min: new(1), Max: new(10),
 	}
 	v := d.ToValue()
 
@@ -63,6 +64,8 @@ func TestToValueMetadata(t *testing.T) {
 		"ui.placeholder":   "enter value",
 		"ui.password":      true,
 		"config.required":  true,
+		"config.min":       1,
+		"config.max":       10,
 	}
 	for k, want := range checks {
 		got, ok := v.Metadata[k]
@@ -95,7 +98,7 @@ func TestToValueOmitsZeroOptionals(t *testing.T) {
 	}
 	v := d.ToValue()
 
-	for _, key := range []string{"ui.placeholder", "ui.password", "config.required", "ui.enum"} {
+	for _, key := range []string{"ui.placeholder", "ui.password", "config.required", "ui.enum", "config.min", "config.max"} {
 		if _, ok := v.Metadata[key]; ok {
 			t.Errorf("metadata key %q should not be set for zero-value optionals", key)
 		}
@@ -167,3 +170,64 @@ func TestPluginSetInvalidPath(t *testing.T) {
 		t.Error("Set with invalid path should return error")
 	}
 }
+
+func TestPluginSetCoercesToDeclaredType(t *testing.T) {
+	p := newHomeserverPlugin()
+	sets := map[string]any{
+		"nextcloud/web-port":      "8081",
+		"core/backup-retain-days": 14.0,
+		"pihole/dnssec":           "false",
+		"mariadb/image-tag":       11.0,
+	}
+	want := map[string]any{
+		"nextcloud/web-port":      8081,
+		"core/backup-retain-days": 14,
+		"pihole/dnssec":           false,
+		"mariadb/image-tag":       "11",
+	}
+	for path, val := range sets {
+		if err := p.Set(context.Background(), path, config.Value{Val: val}); err != nil {
+			t.Fatalf("Set(%q): %v", path, err)
+		}
+		v, _, _ := p.Get(context.Background(), path)
+		if v.Val != want[path] {
+			t.Errorf("Get(%q) = %#v (%T), want %#v", path, v.Val, v.Val, want[path])
+		}
+	}
+}
+
+func TestPluginSetRejectsWrongType(t *testing.T) {
+	p := newHomeserverPlugin()
+	if err := p.Set(context.Background(), "nextcloud/web-port", config.Value{Val: "eighty"}); err == nil {
+		t.Error("Set with non-numeric port should return error")
+	}
+	v, _, _ := p.Get(context.Background(), "nextcloud/web-port")
+	if v.Val != 8080 {
+		t.Errorf("rejected Set changed value to %#v", v.Val)
+	}
+}
+
+func TestToValueFormatMetadata(t *testing.T) {
+	d := ValueDef{
+		Path: "test/domain", Default: "",
+		Section: "S", DisplayName: "D",
+		Description: "Desc", Type: "string", Format: "domain",
+	}
+	v := d.ToValue()
+	if v.Metadata["config.format"] != "domain" {
+		t.Errorf("config.format = %v, want domain", v.Metadata["config.format"])
+	}
+	if v.Metadata["ui.pattern"] != formats["domain"].Pattern {
+		t.Errorf("ui.pattern = %v, want the domain format pattern", v.Metadata["ui.pattern"])
+	}
+
+	d.Pattern = regexp.MustCompile("^custom$")
+	d.Separator = ";"
+	v = d.ToValue()
+	if v.Metadata["ui.pattern"] != "^custom$" {
+		t.Errorf("ui.pattern = %v, want explicit pattern", v.Metadata["ui.pattern"])
+	}
+	if v.Metadata["config.separator"] != ";" {
+		t.Errorf("config.separator = %v, want ;", v.Metadata["config.separator"])
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/MrWong99/zhi/pkg/zhiplugin/config"
+	"gopkg.in/yaml.v3"
+)
+
+// readValuesFile reads a values file: a YAML or JSON object mapping config
+// paths to values, as described by the JSON Schema of the schema command.
+// Nested objects are flattened, so {core: {domain: x}} and
+// {core/domain: x} are the same.
+func readValuesFile(name string) (map[string]any, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]any
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	values := map[string]any{}
+	flattenValues("", doc, values)
+	return values, nil
+}
+
+// flattenValues adds the leaves of m to out, joining nested keys with "/".
+func flattenValues(prefix string, m map[string]any, out map[string]any) {
+	for k, v := range m {
+		path := k
+		if prefix != "" {
+			path = prefix + "/" + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flattenValues(path, sub, out)
+			continue
+		}
+		out[path] = v
+	}
+}
+
+// applyValues sets values on p as zhi would after an edit, keeping the
+// metadata of each path. Values that are rejected are returned with their
+// error, keyed by path. Paths the plugin does not know are rejected too.
+func (p *homeserverPlugin) applyValues(values map[string]any) map[string]error {
+	ctx := context.Background()
+	errs := map[string]error{}
+	for _, path := range slices.Sorted(maps.Keys(values)) {
+		cur, ok, _ := p.Get(ctx, path)
+		if !ok {
+			errs[path] = fmt.Errorf("unknown config path")
+			continue
+		}
+		if err := p.Set(ctx, path, config.Value{Val: values[path], Metadata: cur.Metadata}); err != nil {
+			errs[path] = err
+		}
+	}
+	return errs
+}
+
+// currentTree returns the plugin's current values as a tree, like the
+// tree zhi builds from the plugin and its store.
+func (p *homeserverPlugin) currentTree() *config.Tree {
+	ctx := context.Background()
+	tree := config.NewTree()
+	for _, path := range p.paths {
+		if v, ok, _ := p.Get(ctx, path); ok {
+			tree.Set(path, &v)
+		}
+	}
+	return tree
+}
+
+// validateTree validates every path of tree and returns the paths with
+// results.
+func (p *homeserverPlugin) validateTree(tree config.TreeReader) (map[string][]config.ValidationResult, error) {
+	out := map[string][]config.ValidationResult{}
+	for _, path := range tree.List() {
+		results, err := p.Validate(context.Background(), path, tree)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if len(results) > 0 {
+			out[path] = results
+		}
+	}
+	return out, nil
+}
+
+// envName returns the environment variable name of a config path, e.g.
+// NGINX_PROXY_MANAGER_ADMIN_PORT for nginx-proxy-manager/admin-port.
+func envName(path string) string {
+	return strings.ToUpper(strings.NewReplacer("/", "_", "-", "_").Replace(path))
+}